@@ -0,0 +1,74 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// the nftables table managed by the agent
+const nftablesTable = "hostport_allocator"
+
+// renderRuleset renders an nftables ruleset accepting the allocated ports and dropping the rest of the pool ports
+// for traffic addressed to the node.
+// The ruleset replaces the agent's table atomically when applied with nft -f.
+func renderRuleset(pools portSet, allocated map[boundSocket]struct{}) string {
+	var b strings.Builder
+
+	// declaring the table before deleting it makes the delete succeed when the table doesn't exist yet
+	fmt.Fprintf(&b, "table inet %s\n", nftablesTable)
+	fmt.Fprintf(&b, "delete table inet %s\n", nftablesTable)
+	fmt.Fprintf(&b, "table inet %s {\n", nftablesTable)
+	b.WriteString("\tchain prerouting {\n")
+	// filter before host ports are DNATed to pods so the original destination port is matched
+	b.WriteString("\t\ttype filter hook prerouting priority mangle; policy accept;\n")
+	// only filter new connections to the node, forwarded pod traffic and replies to connections made by the node pass
+	b.WriteString("\t\tfib daddr type != local accept\n")
+	b.WriteString("\t\tct state established,related accept\n")
+
+	protocols := []corev1.Protocol{corev1.ProtocolTCP, corev1.ProtocolUDP, corev1.ProtocolSCTP}
+	for _, protocol := range protocols {
+		ports := make(portSet)
+		for socket := range allocated {
+			if socket.Protocol == protocol {
+				ports[socket.Port] = struct{}{}
+			}
+		}
+
+		if len(ports) > 0 {
+			fmt.Fprintf(&b, "\t\t%s dport { %s } accept\n", strings.ToLower(string(protocol)), nftablesElements(ports))
+		}
+	}
+
+	if len(pools) > 0 {
+		for _, protocol := range protocols {
+			fmt.Fprintf(&b, "\t\t%s dport { %s } drop\n", strings.ToLower(string(protocol)), nftablesElements(pools))
+		}
+	}
+
+	b.WriteString("\t}\n")
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// nftablesElements formats the ports as nftables set elements
+func nftablesElements(ports portSet) string {
+	return strings.ReplaceAll(ports.String(), ",", ", ")
+}
+
+// applyRuleset applies the ruleset with nft in a single transaction
+func applyRuleset(ctx context.Context, nftPath string, ruleset string) error {
+	cmd := exec.CommandContext(ctx, nftPath, "-f", "-")
+	cmd.Stdin = strings.NewReader(ruleset)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("error applying ruleset: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	return nil
+}
@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/rmb938/hostport-allocator/api/meta"
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+	intmetav1 "github.com/rmb938/hostport-allocator/apis/meta/v1"
+)
+
+// the ruleset is periodically applied again in case something else flushed it
+const firewallResyncPeriod = time.Minute
+
+// FirewallReconciler renders an nftables ruleset opening only the ports allocated to or exempted for the pods on the node
+// and dropping the rest of the HostPortClass pools. The ruleset is served on a debug endpoint,
+// and is optionally written to a file and applied.
+type FirewallReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// The name of the node the agent is running on
+	NodeName string
+
+	// Write the ruleset to this file when set
+	RulesetPath string
+
+	// Apply the ruleset with the nft binary at this path when set
+	NFTPath string
+
+	rulesetLock sync.RWMutex
+	ruleset     string
+	appliedAt   time.Time
+}
+
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportexemptions,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+
+func (r *FirewallReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = r.Log.WithValues("hostportnode", req.NamespacedName)
+
+	hostPortClassList := &hostportv1alpha1.HostPortClassList{}
+	err := r.List(ctx, hostPortClassList)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	pools := make(portSet)
+	for _, hpcl := range hostPortClassList.Items {
+		for _, pool := range hpcl.Spec.Pools {
+			pools.addRange(pool.Start, pool.End)
+		}
+	}
+
+	allocated, err := allocatedSockets(ctx, r.Client, r.NodeName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	ruleset := renderRuleset(pools, allocated)
+
+	r.rulesetLock.RLock()
+	unchanged := r.ruleset == ruleset && time.Since(r.appliedAt) < firewallResyncPeriod
+	r.rulesetLock.RUnlock()
+
+	if unchanged {
+		return ctrl.Result{RequeueAfter: firewallResyncPeriod}, nil
+	}
+
+	if len(r.RulesetPath) > 0 {
+		err = os.WriteFile(r.RulesetPath, []byte(ruleset), 0644)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if len(r.NFTPath) > 0 {
+		err = r.apply(ctx, ruleset)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// only remember the ruleset once it is in place so failures are retried
+	r.rulesetLock.Lock()
+	r.ruleset = ruleset
+	r.appliedAt = time.Now()
+	r.rulesetLock.Unlock()
+
+	return ctrl.Result{RequeueAfter: firewallResyncPeriod}, nil
+}
+
+// apply applies the ruleset and reports the result in the HostPortNode status
+func (r *FirewallReconciler) apply(ctx context.Context, ruleset string) error {
+	hpn, err := getHostPortNode(ctx, r.Client, r.Scheme, r.NodeName)
+	if err != nil {
+		return err
+	}
+
+	status := hpn.Status.DeepCopy()
+	applyErr := applyRuleset(ctx, r.NFTPath, ruleset)
+	if applyErr != nil {
+		meta.SetStatusCondition(&status.Conditions, intmetav1.Condition{
+			Type:    hostportv1alpha1.HostPortNodeConditionFirewallApplied,
+			Status:  intmetav1.ConditionFalse,
+			Reason:  "ApplyFailed",
+			Message: applyErr.Error(),
+		})
+	} else {
+		meta.SetStatusCondition(&status.Conditions, intmetav1.Condition{
+			Type:    hostportv1alpha1.HostPortNodeConditionFirewallApplied,
+			Status:  intmetav1.ConditionTrue,
+			Reason:  "Applied",
+			Message: "The firewall only allows the allocated ports",
+		})
+	}
+
+	if equality.Semantic.DeepEqual(&hpn.Status, status) == false {
+		hpn.Status = *status
+		err = r.Status().Update(ctx, hpn)
+		if err != nil {
+			return err
+		}
+	}
+
+	return applyErr
+}
+
+// ServeHTTP serves the last ruleset that was put in place
+func (r *FirewallReconciler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.rulesetLock.RLock()
+	defer r.rulesetLock.RUnlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, _ = w.Write([]byte(r.ruleset))
+}
+
+func (r *FirewallReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	nodeRequest := func(ctx context.Context, object client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: r.NodeName}}}
+	}
+
+	err := mgr.AddMetricsServerExtraHandler("/debug/firewall", r)
+	if err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("firewall").
+		For(&hostportv1alpha1.HostPortNode{}, builder.WithPredicates(nodeNamePredicate(r.NodeName))).
+		Watches(&hostportv1alpha1.HostPortClass{}, handler.EnqueueRequestsFromMapFunc(nodeRequest)).
+		Watches(&hostportv1alpha1.HostPortExemption{}, handler.EnqueueRequestsFromMapFunc(nodeRequest)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(nodeRequest)).
+		Complete(r)
+}
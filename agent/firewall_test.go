@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestRenderRuleset(t *testing.T) {
+	header := "table inet hostport_allocator\n" +
+		"delete table inet hostport_allocator\n" +
+		"table inet hostport_allocator {\n" +
+		"\tchain prerouting {\n" +
+		"\t\ttype filter hook prerouting priority mangle; policy accept;\n" +
+		"\t\tfib daddr type != local accept\n" +
+		"\t\tct state established,related accept\n"
+	footer := "\t}\n}\n"
+
+	pools, err := parsePortRanges("9000-9010")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		pools     portSet
+		allocated map[boundSocket]struct{}
+		expected  string
+	}{
+		{
+			name:     "empty",
+			pools:    make(portSet),
+			expected: header + footer,
+		},
+		{
+			name:  "pools",
+			pools: pools,
+			expected: header +
+				"\t\ttcp dport { 9000-9010 } drop\n" +
+				"\t\tudp dport { 9000-9010 } drop\n" +
+				"\t\tsctp dport { 9000-9010 } drop\n" +
+				footer,
+		},
+		{
+			name:  "allocated",
+			pools: pools,
+			allocated: map[boundSocket]struct{}{
+				{Port: 9000, Protocol: corev1.ProtocolTCP}: {},
+				{Port: 9001, Protocol: corev1.ProtocolTCP}: {},
+				{Port: 9005, Protocol: corev1.ProtocolUDP}: {},
+			},
+			expected: header +
+				"\t\ttcp dport { 9000-9001 } accept\n" +
+				"\t\tudp dport { 9005 } accept\n" +
+				"\t\ttcp dport { 9000-9010 } drop\n" +
+				"\t\tudp dport { 9000-9010 } drop\n" +
+				"\t\tsctp dport { 9000-9010 } drop\n" +
+				footer,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ruleset := renderRuleset(test.pools, test.allocated)
+			if ruleset != test.expected {
+				t.Errorf("unexpected ruleset\n%s\nexpected\n%s", ruleset, test.expected)
+			}
+		})
+	}
+}
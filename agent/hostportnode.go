@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportnodes,verbs=get;list;watch;create
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportnodes/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get
+
+// getHostPortNode returns the HostPortNode of the node, creating it if it doesn't exist.
+// The HostPortNode is owned by the Node so it is removed with the node.
+func getHostPortNode(ctx context.Context, c client.Client, scheme *runtime.Scheme, nodeName string) (*hostportv1alpha1.HostPortNode, error) {
+	hpn := &hostportv1alpha1.HostPortNode{}
+	err := c.Get(ctx, types.NamespacedName{Name: nodeName}, hpn)
+	if err == nil {
+		return hpn, nil
+	}
+	if apierrors.IsNotFound(err) == false {
+		return nil, err
+	}
+
+	node := &corev1.Node{}
+	err = c.Get(ctx, types.NamespacedName{Name: nodeName}, node)
+	if err != nil {
+		return nil, err
+	}
+
+	hpn.Name = nodeName
+	err = controllerutil.SetOwnerReference(node, hpn, scheme)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.Create(ctx, hpn)
+	if err != nil {
+		return nil, err
+	}
+
+	return hpn, nil
+}
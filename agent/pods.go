@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+// allocatedSockets returns the ports allocated to the running pods on the node
+// and the host ports set by the running pods exempted by a HostPortExemption
+func allocatedSockets(ctx context.Context, c client.Client, nodeName string) (map[boundSocket]struct{}, error) {
+	podList := &corev1.PodList{}
+	err := c.List(ctx, podList)
+	if err != nil {
+		return nil, err
+	}
+
+	hostPortExemptionList := &hostportv1alpha1.HostPortExemptionList{}
+	err = c.List(ctx, hostPortExemptionList)
+	if err != nil {
+		return nil, err
+	}
+
+	sockets := make(map[boundSocket]struct{})
+	for _, pod := range podList.Items {
+		if pod.Spec.NodeName != nodeName || pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		for annotation, value := range pod.Annotations {
+			if strings.HasPrefix(annotation, hostportv1alpha1.HostPortPodAnnotationPortPrefix+"/") == false {
+				continue
+			}
+
+			port, err := strconv.Atoi(value)
+			if err != nil {
+				continue
+			}
+
+			portName := strings.Split(annotation, "/")[1]
+			protocol := corev1.ProtocolTCP
+			for _, containers := range [][]corev1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+				for _, container := range containers {
+					for _, containerPort := range container.Ports {
+						if containerPort.Name == portName && len(containerPort.Protocol) > 0 {
+							protocol = containerPort.Protocol
+						}
+					}
+				}
+			}
+
+			sockets[boundSocket{Port: port, Protocol: protocol}] = struct{}{}
+		}
+
+		for _, socket := range exemptedSockets(hostPortExemptionList.Items, &pod) {
+			sockets[socket] = struct{}{}
+		}
+	}
+
+	return sockets, nil
+}
+
+// exemptedSockets returns the host ports set by the pod that are exempted by the HostPortExemptions matching it
+func exemptedSockets(exemptions []hostportv1alpha1.HostPortExemption, pod *corev1.Pod) []boundSocket {
+	var exemptPorts []hostportv1alpha1.HostPortExemptionPort
+	for _, hpe := range exemptions {
+		// exemptions that fail to match are skipped, the pod webhook did not use them either
+		matches, err := hpe.Matches(pod)
+		if err != nil || matches == false {
+			continue
+		}
+
+		exemptPorts = append(exemptPorts, hpe.Spec.Ports...)
+	}
+
+	if len(exemptPorts) == 0 {
+		return nil
+	}
+
+	var sockets []boundSocket
+	for _, containers := range [][]corev1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+		for _, container := range containers {
+			for _, containerPort := range container.Ports {
+				if containerPort.HostPort == 0 {
+					continue
+				}
+
+				for _, exemptPort := range exemptPorts {
+					if exemptPort.Exempts(&containerPort) {
+						protocol := containerPort.Protocol
+						if len(protocol) == 0 {
+							protocol = corev1.ProtocolTCP
+						}
+
+						sockets = append(sockets, boundSocket{Port: int(containerPort.HostPort), Protocol: protocol})
+						break
+					}
+				}
+			}
+		}
+	}
+
+	return sockets
+}
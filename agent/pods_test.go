@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+func TestAllocatedSockets(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = hostportv1alpha1.AddToScheme(scheme)
+
+	exemption := &hostportv1alpha1.HostPortExemption{
+		ObjectMeta: metav1.ObjectMeta{Name: "ingress"},
+		Spec: hostportv1alpha1.HostPortExemptionSpec{
+			Namespaces: []string{"ingress"},
+			Ports:      []hostportv1alpha1.HostPortExemptionPort{{Port: 80}, {Port: 443, Protocol: corev1.ProtocolTCP}},
+		},
+	}
+
+	pod := func(namespace string, nodeName string, phase corev1.PodPhase, annotations map[string]string, ports ...corev1.ContainerPort) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: "pod", Annotations: annotations},
+			Spec: corev1.PodSpec{
+				NodeName:   nodeName,
+				Containers: []corev1.Container{{Name: "app", Ports: ports}},
+			},
+			Status: corev1.PodStatus{Phase: phase},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		expected map[boundSocket]struct{}
+	}{
+		{
+			name: "allocated port",
+			pod: pod("default", "node", corev1.PodRunning,
+				map[string]string{hostportv1alpha1.HostPortPodAnnotationPortPrefix + "/game": "9000"},
+				corev1.ContainerPort{Name: "game", ContainerPort: 7777, HostPort: 9000, Protocol: corev1.ProtocolUDP}),
+			expected: map[boundSocket]struct{}{{Port: 9000, Protocol: corev1.ProtocolUDP}: {}},
+		},
+		{
+			name: "exempted pod",
+			pod: pod("ingress", "node", corev1.PodRunning, nil,
+				corev1.ContainerPort{ContainerPort: 80, HostPort: 80},
+				corev1.ContainerPort{ContainerPort: 80, HostPort: 80, Protocol: corev1.ProtocolUDP},
+				corev1.ContainerPort{ContainerPort: 443, HostPort: 443, Protocol: corev1.ProtocolUDP},
+				corev1.ContainerPort{ContainerPort: 8080}),
+			expected: map[boundSocket]struct{}{
+				{Port: 80, Protocol: corev1.ProtocolTCP}: {},
+				{Port: 80, Protocol: corev1.ProtocolUDP}: {},
+			},
+		},
+		{
+			name:     "pod not exempted",
+			pod:      pod("default", "node", corev1.PodRunning, nil, corev1.ContainerPort{ContainerPort: 80, HostPort: 80}),
+			expected: map[boundSocket]struct{}{},
+		},
+		{
+			name:     "exempted pod on another node",
+			pod:      pod("ingress", "other", corev1.PodRunning, nil, corev1.ContainerPort{ContainerPort: 80, HostPort: 80}),
+			expected: map[boundSocket]struct{}{},
+		},
+		{
+			name:     "finished exempted pod",
+			pod:      pod("ingress", "node", corev1.PodSucceeded, nil, corev1.ContainerPort{ContainerPort: 80, HostPort: 80}),
+			expected: map[boundSocket]struct{}{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(exemption, test.pod).Build()
+
+			sockets, err := allocatedSockets(context.Background(), c, "node")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reflect.DeepEqual(sockets, test.expected) == false {
+				t.Errorf("unexpected sockets %v", sockets)
+			}
+		})
+	}
+}
@@ -0,0 +1,144 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/rmb938/hostport-allocator/api/meta"
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+	intmetav1 "github.com/rmb938/hostport-allocator/apis/meta/v1"
+)
+
+// sockets can't be watched so they are scanned periodically
+const portConflictScanPeriod = time.Minute
+
+// PortConflictReconciler scans the sockets bound on the node and reports the ports inside
+// HostPortClass pools that are bound by something other than the pods allocated or exempted the ports
+type PortConflictReconciler struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// The name of the node the agent is running on
+	NodeName string
+
+	// The root of the proc filesystem to scan for sockets
+	ProcRoot string
+}
+
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportclasses,verbs=get;list;watch
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportexemptions,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
+
+func (r *PortConflictReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = r.Log.WithValues("hostportnode", req.NamespacedName)
+
+	hpn, err := getHostPortNode(ctx, r.Client, r.Scheme, r.NodeName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	hostPortClassList := &hostportv1alpha1.HostPortClassList{}
+	err = r.List(ctx, hostPortClassList)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	pools := make(portSet)
+	for _, hpcl := range hostPortClassList.Items {
+		for _, pool := range hpcl.Spec.Pools {
+			pools.addRange(pool.Start, pool.End)
+		}
+	}
+
+	expected, err := allocatedSockets(ctx, r.Client, r.NodeName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	sockets, err := boundSockets(r.ProcRoot)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	previousConflicts := make(map[hostportv1alpha1.HostPortNodeConflict]struct{})
+	for _, conflict := range hpn.Status.Conflicts {
+		previousConflicts[conflict] = struct{}{}
+	}
+
+	var conflicts []hostportv1alpha1.HostPortNodeConflict
+	for _, socket := range sockets {
+		if _, ok := pools[socket.Port]; !ok {
+			continue
+		}
+
+		if _, ok := expected[socket]; ok {
+			continue
+		}
+
+		conflict := hostportv1alpha1.HostPortNodeConflict{Port: socket.Port, Protocol: socket.Protocol}
+		conflicts = append(conflicts, conflict)
+
+		if _, ok := previousConflicts[conflict]; !ok {
+			r.Recorder.Eventf(hpn, corev1.EventTypeWarning, "PortConflict",
+				"Port %d/%s inside a HostPortClass pool is bound by something other than a pod allocated the port", socket.Port, socket.Protocol)
+		}
+	}
+
+	status := hpn.Status.DeepCopy()
+	status.Conflicts = conflicts
+	if len(conflicts) > 0 {
+		meta.SetStatusCondition(&status.Conditions, intmetav1.Condition{
+			Type:    hostportv1alpha1.HostPortNodeConditionPortConflicts,
+			Status:  intmetav1.ConditionTrue,
+			Reason:  "ConflictsFound",
+			Message: fmt.Sprintf("%d ports inside HostPortClass pools are bound by something other than a pod allocated the port", len(conflicts)),
+		})
+	} else {
+		meta.SetStatusCondition(&status.Conditions, intmetav1.Condition{
+			Type:    hostportv1alpha1.HostPortNodeConditionPortConflicts,
+			Status:  intmetav1.ConditionFalse,
+			Reason:  "NoConflicts",
+			Message: "No ports inside HostPortClass pools are bound by something other than a pod allocated the port",
+		})
+	}
+
+	if equality.Semantic.DeepEqual(&hpn.Status, status) == false {
+		hpn.Status = *status
+		err = r.Status().Update(ctx, hpn)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{RequeueAfter: portConflictScanPeriod}, nil
+}
+
+func (r *PortConflictReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	nodeRequest := func(ctx context.Context, object client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: r.NodeName}}}
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("portconflict").
+		For(&hostportv1alpha1.HostPortNode{}, builder.WithPredicates(nodeNamePredicate(r.NodeName))).
+		Watches(&hostportv1alpha1.HostPortClass{}, handler.EnqueueRequestsFromMapFunc(nodeRequest)).
+		Watches(&hostportv1alpha1.HostPortExemption{}, handler.EnqueueRequestsFromMapFunc(nodeRequest)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(nodeRequest)).
+		Complete(r)
+}
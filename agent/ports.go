@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// portSet is a set of ports
+type portSet map[int]struct{}
+
+// addRange adds the ports from start to end inclusive
+func (p portSet) addRange(start, end int) {
+	for port := start; port <= end; port++ {
+		p[port] = struct{}{}
+	}
+}
+
+// String formats the ports as sorted comma separated ranges, for example "80,9000-9500"
+func (p portSet) String() string {
+	ports := make([]int, 0, len(p))
+	for port := range p {
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	var ranges []string
+	for i := 0; i < len(ports); {
+		start := ports[i]
+		end := start
+		for i+1 < len(ports) && ports[i+1] == end+1 {
+			i++
+			end = ports[i]
+		}
+		i++
+
+		if start == end {
+			ranges = append(ranges, strconv.Itoa(start))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", start, end))
+		}
+	}
+
+	return strings.Join(ranges, ",")
+}
+
+// parsePortRanges parses comma separated ports and port ranges in the format of ip_local_reserved_ports
+func parsePortRanges(value string) (portSet, error) {
+	ports := make(portSet)
+
+	for _, part := range strings.Split(strings.TrimSpace(value), ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+
+		bounds := strings.SplitN(part, "-", 2)
+		start, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid port range %q: %w", part, err)
+		}
+
+		end := start
+		if len(bounds) == 2 {
+			end, err = strconv.Atoi(bounds[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid port range %q: %w", part, err)
+			}
+		}
+
+		if start < 0 || end > 65535 || start > end {
+			return nil, fmt.Errorf("invalid port range %q", part)
+		}
+
+		ports.addRange(start, end)
+	}
+
+	return ports, nil
+}
@@ -0,0 +1,118 @@
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DefaultProcRoot is the root of the proc filesystem the agent scans for sockets
+const DefaultProcRoot = "/proc"
+
+// the tcp state of listening sockets in /proc/net/tcp
+const procNetTCPListen = "0A"
+
+// boundSocket is a port bound by a socket on the node
+type boundSocket struct {
+	Port     int
+	Protocol corev1.Protocol
+}
+
+// boundSockets returns the listening tcp and bound udp ports of the network namespace
+// the agent is running in from the proc filesystem at procRoot
+func boundSockets(procRoot string) ([]boundSocket, error) {
+	seen := make(map[boundSocket]struct{})
+
+	for _, file := range []struct {
+		name     string
+		protocol corev1.Protocol
+	}{
+		{name: "tcp", protocol: corev1.ProtocolTCP},
+		{name: "tcp6", protocol: corev1.ProtocolTCP},
+		{name: "udp", protocol: corev1.ProtocolUDP},
+		{name: "udp6", protocol: corev1.ProtocolUDP},
+	} {
+		f, err := os.Open(filepath.Join(procRoot, "net", file.name))
+		if err != nil {
+			// ipv6 may be disabled
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return nil, err
+		}
+
+		ports, err := parseProcNet(f, file.protocol == corev1.ProtocolTCP)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s: %w", f.Name(), err)
+		}
+
+		for _, port := range ports {
+			seen[boundSocket{Port: port, Protocol: file.protocol}] = struct{}{}
+		}
+	}
+
+	sockets := make([]boundSocket, 0, len(seen))
+	for socket := range seen {
+		sockets = append(sockets, socket)
+	}
+	sort.Slice(sockets, func(i, j int) bool {
+		if sockets[i].Port != sockets[j].Port {
+			return sockets[i].Port < sockets[j].Port
+		}
+		return sockets[i].Protocol < sockets[j].Protocol
+	})
+
+	return sockets, nil
+}
+
+// parseProcNet returns the local ports of the sockets in a /proc/net/{tcp,udp}{,6} file,
+// only listening sockets are returned when listenOnly is true
+func parseProcNet(r io.Reader, listenOnly bool) ([]int, error) {
+	var ports []int
+
+	scanner := bufio.NewScanner(r)
+	header := true
+	for scanner.Scan() {
+		if header {
+			header = false
+			continue
+		}
+
+		// sl local_address rem_address st ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 {
+			continue
+		}
+
+		if listenOnly && fields[3] != procNetTCPListen {
+			continue
+		}
+
+		localAddress := strings.Split(fields[1], ":")
+		if len(localAddress) != 2 {
+			return nil, fmt.Errorf("invalid local address %q", fields[1])
+		}
+
+		port, err := strconv.ParseUint(localAddress[1], 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid local address %q: %w", fields[1], err)
+		}
+
+		ports = append(ports, int(port))
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return ports, nil
+}
@@ -0,0 +1,25 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestBoundSockets(t *testing.T) {
+	sockets, err := boundSockets("testdata/proc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []boundSocket{
+		{Port: 8080, Protocol: corev1.ProtocolTCP},
+		{Port: 9000, Protocol: corev1.ProtocolTCP},
+		{Port: 9008, Protocol: corev1.ProtocolUDP},
+	}
+
+	if reflect.DeepEqual(sockets, expected) == false {
+		t.Errorf("unexpected sockets %v", sockets)
+	}
+}
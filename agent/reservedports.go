@@ -0,0 +1,40 @@
+package agent
+
+import (
+	"os"
+)
+
+// DefaultReservedPortsPath is the sysctl file of net.ipv4.ip_local_reserved_ports
+const DefaultReservedPortsPath = "/proc/sys/net/ipv4/ip_local_reserved_ports"
+
+// syncReservedPorts reserves the desired ports in the reserved ports file at path.
+// Ports in previous were reserved by the agent before and are released if they are no longer desired,
+// ports reserved by anything else are kept.
+func syncReservedPorts(path string, previous, desired portSet) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	current, err := parsePortRanges(string(content))
+	if err != nil {
+		return err
+	}
+
+	next := make(portSet)
+	for port := range current {
+		if _, ok := previous[port]; ok {
+			continue
+		}
+		next[port] = struct{}{}
+	}
+	for port := range desired {
+		next[port] = struct{}{}
+	}
+
+	if next.String() == current.String() {
+		return nil
+	}
+
+	return os.WriteFile(path, []byte(next.String()+"\n"), 0644)
+}
@@ -0,0 +1,120 @@
+package agent
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/rmb938/hostport-allocator/api/meta"
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+	intmetav1 "github.com/rmb938/hostport-allocator/apis/meta/v1"
+)
+
+// the reserved ports are periodically resynced in case something else changed them
+const reservedPortsResyncPeriod = 5 * time.Minute
+
+// ReservedPortsReconciler keeps the pools of all HostPortClasses reserved in the node's
+// net.ipv4.ip_local_reserved_ports so they are never used as ephemeral source ports
+type ReservedPortsReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+
+	// The name of the node the agent is running on
+	NodeName string
+
+	// The path of the net.ipv4.ip_local_reserved_ports sysctl file
+	ReservedPortsPath string
+}
+
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportclasses,verbs=get;list;watch
+
+func (r *ReservedPortsReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("hostportnode", req.NamespacedName)
+
+	hpn, err := getHostPortNode(ctx, r.Client, r.Scheme, r.NodeName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	hostPortClassList := &hostportv1alpha1.HostPortClassList{}
+	err = r.List(ctx, hostPortClassList)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	desired := make(portSet)
+	for _, hpcl := range hostPortClassList.Items {
+		for _, pool := range hpcl.Spec.Pools {
+			desired.addRange(pool.Start, pool.End)
+		}
+	}
+
+	previous, err := parsePortRanges(hpn.Status.ReservedPorts)
+	if err != nil {
+		log.Error(err, "ignoring invalid previously reserved ports")
+		previous = make(portSet)
+	}
+
+	status := hpn.Status.DeepCopy()
+	syncErr := syncReservedPorts(r.ReservedPortsPath, previous, desired)
+	if syncErr != nil {
+		meta.SetStatusCondition(&status.Conditions, intmetav1.Condition{
+			Type:    hostportv1alpha1.HostPortNodeConditionReservedPortsSynced,
+			Status:  intmetav1.ConditionFalse,
+			Reason:  "SyncFailed",
+			Message: syncErr.Error(),
+		})
+	} else {
+		status.ReservedPorts = desired.String()
+		meta.SetStatusCondition(&status.Conditions, intmetav1.Condition{
+			Type:    hostportv1alpha1.HostPortNodeConditionReservedPortsSynced,
+			Status:  intmetav1.ConditionTrue,
+			Reason:  "Synced",
+			Message: "The pools of all HostPortClasses are reserved",
+		})
+	}
+
+	if equality.Semantic.DeepEqual(&hpn.Status, status) == false {
+		hpn.Status = *status
+		err = r.Status().Update(ctx, hpn)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	if syncErr != nil {
+		return ctrl.Result{}, syncErr
+	}
+
+	return ctrl.Result{RequeueAfter: reservedPortsResyncPeriod}, nil
+}
+
+func (r *ReservedPortsReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	nodeRequest := func(ctx context.Context, object client.Object) []reconcile.Request {
+		return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: r.NodeName}}}
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("reservedports").
+		For(&hostportv1alpha1.HostPortNode{}, builder.WithPredicates(nodeNamePredicate(r.NodeName))).
+		Watches(&hostportv1alpha1.HostPortClass{}, handler.EnqueueRequestsFromMapFunc(nodeRequest)).
+		Complete(r)
+}
+
+// nodeNamePredicate filters objects to the one named after the node
+func nodeNamePredicate(nodeName string) predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(object client.Object) bool {
+		return object.GetName() == nodeName
+	})
+}
@@ -0,0 +1,56 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncReservedPorts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ip_local_reserved_ports")
+	err := os.WriteFile(path, []byte("22,8000-8010\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous, err := parsePortRanges("8005-8010")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desired, err := parsePortRanges("9000-9100,9200")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = syncReservedPorts(path, previous, desired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(content) != "22,8000-8004,9000-9100,9200\n" {
+		t.Errorf("unexpected reserved ports %q", content)
+	}
+}
+
+func TestParsePortRanges(t *testing.T) {
+	ports, err := parsePortRanges("80, 443,1000-1002\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ports.String() != "80,443,1000-1002" {
+		t.Errorf("unexpected ports %q", ports.String())
+	}
+
+	for _, value := range []string{"a", "10-5", "70000"} {
+		if _, err := parsePortRanges(value); err == nil {
+			t.Errorf("expected error parsing %q", value)
+		}
+	}
+}
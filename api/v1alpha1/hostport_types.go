@@ -23,6 +23,16 @@ import (
 	intmetav1 "github.com/rmb938/hostport-allocator/apis/meta/v1"
 )
 
+var (
+	// Labels marking a dynamically provisioned HostPort as owned by a HostPortClaim.
+	// HostPorts are cluster scoped so they can't have an owner reference to a namespaced HostPortClaim.
+	HostPortLabelClaimNamespace = GroupVersion.Group + "/claim-namespace"
+	HostPortLabelClaimUID       = GroupVersion.Group + "/claim-uid"
+
+	// Annotation on a Node listing the comma separated host ports taken by processes outside of Kubernetes
+	HostPortNodeAnnotationTakenPorts = GroupVersion.Group + "/taken-ports"
+)
+
 type HostPortPhase string
 
 const (
@@ -32,6 +42,11 @@ const (
 	HostPortPhaseDeleting HostPortPhase = "Deleting"
 )
 
+const (
+	// HostPortConditionWaiting is true while the HostPortClass has no free port for the HostPort
+	HostPortConditionWaiting = "Waiting"
+)
+
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
@@ -46,6 +61,19 @@ type HostPortSpec struct {
 
 	// +kubebuilder:validation:Required
 	HostPortClassName string `json:"hostPortClassName"`
+
+	// The port to allocate if it is free in the HostPortClass
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	PreferredPort int `json:"preferredPort,omitempty"`
+
+	// HostPorts waiting for a free port with a higher priority are allocated first,
+	// HostPorts with the same priority are allocated in the order they were created
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=-1000
+	// +kubebuilder:validation:Maximum=1000
+	Priority int `json:"priority,omitempty"`
 }
 
 // HostPortStatus defines the observed state of HostPort
@@ -17,6 +17,7 @@ limitations under the License.
 package v1alpha1
 
 import (
+	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	intmetav1 "github.com/rmb938/hostport-allocator/apis/meta/v1"
@@ -28,13 +29,73 @@ import (
 var (
 	HostPortPodAnnotationClaimPrefix = "claim." + GroupVersion.Group
 	HostPortPodAnnotationPortPrefix  = "port." + GroupVersion.Group
+
+	// Add the claimed port to the named container when no container declares it.
+	// The value is the container name optionally followed by /<protocol>, for example "game/UDP".
+	HostPortPodAnnotationContainerPrefix = "container." + GroupVersion.Group
+
+	// Inject the allocated ports as environment variables into the pod's containers.
+	// The value is either "true" for all containers or a comma separated list of container names.
+	HostPortPodAnnotationInjectEnv = GroupVersion.Group + "/inject-env"
+
+	// Override the port mode of all claims used by the pod
+	HostPortPodAnnotationPortMode = GroupVersion.Group + "/port-mode"
+
+	// Publish the endpoints of the pods using the claim as a Service with the same name as the claim when "true"
+	HostPortClaimAnnotationPublishService = GroupVersion.Group + "/publish-service"
+
+	// Publish ExternalDNS records for the ready endpoints of the claim in the DNS zone
+	HostPortClaimAnnotationDNSZone = GroupVersion.Group + "/dns-zone"
+	// The TTL of the published DNS records
+	HostPortClaimAnnotationDNSTTL = GroupVersion.Group + "/dns-ttl"
+	// The service of the published SRV record, defaults to the claim name
+	HostPortClaimAnnotationDNSSRVService = GroupVersion.Group + "/dns-srv-service"
+	// The protocol of the published SRV record, defaults to tcp
+	HostPortClaimAnnotationDNSSRVProtocol = GroupVersion.Group + "/dns-srv-protocol"
 )
 
 type HostPortClaimStatusPhase string
 
 const (
-	HostPortClaimPhasePending HostPortClaimStatusPhase = "Pending"
-	HostPortClaimPhaseBound   HostPortClaimStatusPhase = "Bound"
+	// HostPortClaimConditionLost is true when the HostPort bound to the claim no longer exists
+	HostPortClaimConditionLost = "Lost"
+)
+
+// +kubebuilder:validation:Enum=Mapped;Identity
+type HostPortClaimPortMode string
+
+const (
+	// Only set the host port of the container port to the allocated port
+	HostPortClaimPortModeMapped HostPortClaimPortMode = "Mapped"
+	// Set both the container port and the host port to the allocated port
+	HostPortClaimPortModeIdentity HostPortClaimPortMode = "Identity"
+)
+
+// +kubebuilder:validation:Enum=None;Reprovision
+type HostPortClaimLostRecoveryPolicy string
+
+const (
+	// Leave the claim lost until its HostPort is recreated
+	HostPortClaimLostRecoveryPolicyNone HostPortClaimLostRecoveryPolicy = "None"
+	// Provision a new HostPort for the claim, preferring the previously allocated port
+	HostPortClaimLostRecoveryPolicyReprovision HostPortClaimLostRecoveryPolicy = "Reprovision"
+)
+
+// +kubebuilder:validation:Enum=Delete;Release
+type HostPortClaimUnusedReleasePolicy string
+
+const (
+	// Delete the claim once it has been unused for releaseAfterUnused
+	HostPortClaimUnusedReleasePolicyDelete HostPortClaimUnusedReleasePolicy = "Delete"
+	// Release the HostPort backing the claim once it has been unused for releaseAfterUnused
+	HostPortClaimUnusedReleasePolicyRelease HostPortClaimUnusedReleasePolicy = "Release"
+)
+
+const (
+	HostPortClaimPhasePending  HostPortClaimStatusPhase = "Pending"
+	HostPortClaimPhaseBound    HostPortClaimStatusPhase = "Bound"
+	HostPortClaimPhaseReleased HostPortClaimStatusPhase = "Released"
+	HostPortClaimPhaseLost     HostPortClaimStatusPhase = "Lost"
 
 	HostPortClaimPhaseDeleting HostPortClaimStatusPhase = "Deleting"
 )
@@ -48,9 +109,60 @@ type HostPortClaimSpec struct {
 	// +kubebuilder:validation:Required
 	HostPortClassName string `json:"hostPortClassName"`
 
+	// Classes to provision the HostPort from in order of preference when hostPortClassName has no free ports.
+	// The class is chosen when the HostPort is provisioned, when every class is exhausted the HostPort waits
+	// for a free port in the most preferred class and is not moved when a fallback class frees ports later.
+	// +kubebuilder:validation:Optional
+	FallbackHostPortClassNames []string `json:"fallbackHostPortClassNames,omitempty"`
+
 	// The binding reference to the HostPort backing this claim
 	// +kubebuilder:validation:Optional
 	HostPortName string `json:"hostPortName"`
+
+	// The priority of the HostPort provisioned for the claim when it waits for a free port in the class.
+	// The priority may not be higher than the maxPriority of any of the claim's classes.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=-1000
+	// +kubebuilder:validation:Maximum=1000
+	Priority int `json:"priority,omitempty"`
+
+	// How pods using the claim are given the allocated port.
+	// Pods using the host network always use the Identity mode.
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Mapped
+	PortMode HostPortClaimPortMode `json:"portMode,omitempty"`
+
+	// Release the claim after no pods have used it for this duration
+	// +kubebuilder:validation:Optional
+	ReleaseAfterUnused *metav1.Duration `json:"releaseAfterUnused,omitempty"`
+
+	// What to do with the claim once it has been unused for releaseAfterUnused
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=Delete
+	UnusedReleasePolicy HostPortClaimUnusedReleasePolicy `json:"unusedReleasePolicy,omitempty"`
+
+	// What to do when the HostPort bound to the claim no longer exists
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:default=None
+	LostRecoveryPolicy HostPortClaimLostRecoveryPolicy `json:"lostRecoveryPolicy,omitempty"`
+}
+
+type HostPortClaimEndpoint struct {
+	// The name of the pod using the claim
+	Pod string `json:"pod"`
+
+	// The node the pod is running on
+	// +kubebuilder:validation:Optional
+	Node string `json:"node,omitempty"`
+
+	// The IP of the node the pod is running on
+	NodeIP string `json:"nodeIP"`
+
+	// The host port the pod is reachable on
+	Port int `json:"port"`
+
+	// Whether the pod is ready
+	Ready bool `json:"ready"`
 }
 
 // HostPortClaimStatus defines the observed state of HostPortClaim
@@ -64,6 +176,32 @@ type HostPortClaimStatus struct {
 
 	// +kubebuilder:validation:Optional
 	Phase HostPortClaimStatusPhase `json:"phase,omitempty"`
+
+	// The class of the HostPort bound to the claim
+	// +kubebuilder:validation:Optional
+	HostPortClassName string `json:"hostPortClassName,omitempty"`
+
+	// The port allocated to the bound HostPort
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=65535
+	Port int `json:"port,omitempty"`
+
+	// The pods using the claim, only the first 100 pods sorted by name are listed
+	// +kubebuilder:validation:Optional
+	Users []v1.ObjectReference `json:"users,omitempty"`
+
+	// The number of pods using the claim, including pods not listed in users
+	// +kubebuilder:validation:Optional
+	UserCount int `json:"userCount"`
+
+	// Where the pods using the claim are reachable
+	// +kubebuilder:validation:Optional
+	Endpoints []HostPortClaimEndpoint `json:"endpoints,omitempty"`
+
+	// The time since which no pods have used the claim
+	// +kubebuilder:validation:Optional
+	UnusedSince *metav1.Time `json:"unusedSince,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -71,8 +209,10 @@ type HostPortClaimStatus struct {
 // +kubebuilder:storageversion
 // +kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="STATUS",type=string,JSONPath=`.status.phase`,priority=0
-// +kubebuilder:printcolumn:name="HOSTPORTCLASS",type=string,JSONPath=`.spec.hostPortClassName`,priority=0
+// +kubebuilder:printcolumn:name="HOSTPORTCLASS",type=string,JSONPath=`.status.hostPortClassName`,priority=0
 // +kubebuilder:printcolumn:name="HOSTPORT",type=string,JSONPath=`.spec.hostPortName`,priority=0
+// +kubebuilder:printcolumn:name="PORT",type=integer,JSONPath=`.status.port`,priority=0
+// +kubebuilder:printcolumn:name="USERS",type=integer,JSONPath=`.status.userCount`,priority=0
 // +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
 
 // HostPortClaim is the Schema for the hostportclaims API
@@ -96,6 +236,20 @@ type HostPortClaimList struct {
 	Items           []HostPortClaim `json:"items"`
 }
 
+// HostPortClassNames returns the classes the claim may be provisioned from in order of preference
+func (in *HostPortClaim) HostPortClassNames() []string {
+	return append([]string{in.Spec.HostPortClassName}, in.Spec.FallbackHostPortClassNames...)
+}
+
+// BoundHostPortClassName returns the class of the HostPort bound to the claim,
+// claims that are not bound yet return their most preferred class
+func (in *HostPortClaim) BoundHostPortClassName() string {
+	if len(in.Status.HostPortClassName) > 0 {
+		return in.Status.HostPortClassName
+	}
+	return in.Spec.HostPortClassName
+}
+
 func init() {
 	SchemeBuilder.Register(&HostPortClaim{}, &HostPortClaimList{})
 }
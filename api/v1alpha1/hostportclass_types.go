@@ -24,6 +24,10 @@ import (
 // NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
 
 type HostPortClassSpecPool struct {
+	// The name of the pool, used when reporting pool usage
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+
 	// The start port for the pool
 	// +kubebuilder:validation:Required
 	// +kubebuilder:validation:Minimum=1
@@ -35,6 +39,11 @@ type HostPortClassSpecPool struct {
 	// +kubebuilder:validation:Minimum=1
 	// +kubebuilder:validation:Maximum=65535
 	End int `json:"end"`
+
+	// Reserve the pool for namespaces matching the selector.
+	// Claims from those namespaces are served from their dedicated pools before any shared pools.
+	// +kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
 }
 
 // HostPortClassSpec defines the desired state of HostPortClass
@@ -44,12 +53,63 @@ type HostPortClassSpec struct {
 
 	// +kubebuilder:validation:Required
 	Pools []HostPortClassSpecPool `json:"pools"`
+
+	// The namespaces allowed to claim from this class.
+	// If allowedNamespaces and namespaceSelector are both empty all namespaces are allowed,
+	// otherwise a namespace must be listed here or match the namespaceSelector.
+	// +kubebuilder:validation:Optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+
+	// A label selector for namespaces allowed to claim from this class
+	// +kubebuilder:validation:Optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Require the creator of a claim to be allowed the "use" verb on this class
+	// +kubebuilder:validation:Optional
+	RequireUsePermission bool `json:"requireUsePermission,omitempty"`
+
+	// Allow namespaces with dedicated pools to fall back to the shared pools
+	// once their dedicated pools are exhausted
+	// +kubebuilder:validation:Optional
+	AllowSharedPoolFallback bool `json:"allowSharedPoolFallback,omitempty"`
+
+	// The highest priority claims of this class may set, claims may always lower their priority
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=1000
+	MaxPriority int `json:"maxPriority,omitempty"`
+}
+
+type HostPortClassStatusPool struct {
+	// The name of the pool
+	// +kubebuilder:validation:Optional
+	Name string `json:"name,omitempty"`
+
+	// The start port for the pool
+	Start int `json:"start"`
+
+	// The end port for the pool
+	End int `json:"end"`
+
+	// Whether the pool is reserved for selected namespaces
+	// +kubebuilder:validation:Optional
+	Dedicated bool `json:"dedicated,omitempty"`
+
+	// The number of ports in the pool
+	Capacity int `json:"capacity"`
+
+	// The number of ports in the pool allocated to HostPorts of this class
+	Allocated int `json:"allocated"`
 }
 
 // HostPortClassStatus defines the observed state of HostPortClass
 type HostPortClassStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// Usage of each pool in the class
+	// +kubebuilder:validation:Optional
+	Pools []HostPortClassStatusPool `json:"pools,omitempty"`
 }
 
 // +kubebuilder:object:root=true
@@ -0,0 +1,160 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"slices"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+type HostPortExemptionServiceAccount struct {
+	// The namespace of the service account
+	// +kubebuilder:validation:Required
+	Namespace string `json:"namespace"`
+
+	// The name of the service account
+	// +kubebuilder:validation:Required
+	Name string `json:"name"`
+}
+
+type HostPortExemptionPort struct {
+	// The host port pods are allowed to set
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int `json:"port"`
+
+	// The protocol of the port, any protocol is allowed if empty
+	// +kubebuilder:validation:Optional
+	// +kubebuilder:validation:Enum=TCP;UDP;SCTP
+	Protocol v1.Protocol `json:"protocol,omitempty"`
+}
+
+// HostPortExemptionSpec defines the desired state of HostPortExemption
+type HostPortExemptionSpec struct {
+	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+
+	// The namespaces of exempted pods
+	// +kubebuilder:validation:Optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// The service accounts of exempted pods
+	// +kubebuilder:validation:Optional
+	ServiceAccounts []HostPortExemptionServiceAccount `json:"serviceAccounts,omitempty"`
+
+	// A label selector for exempted pods
+	// +kubebuilder:validation:Optional
+	PodSelector *metav1.LabelSelector `json:"podSelector,omitempty"`
+
+	// The host ports exempted pods are allowed to set.
+	// These ports are reserved and never allocated to a HostPort.
+	// +kubebuilder:validation:Required
+	// +kubebuilder:validation:MinItems=1
+	Ports []HostPortExemptionPort `json:"ports"`
+}
+
+// HostPortExemptionStatus defines the observed state of HostPortExemption
+type HostPortExemptionStatus struct {
+	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=hpe
+// +kubebuilder:storageversion
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// HostPortExemption is the Schema for the hostportexemptions API.
+// A pod is exempted when it matches every namespace, service account and pod selector criteria that is set,
+// an exemption without any criteria exempts no pods.
+type HostPortExemption struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// +kubebuilder:validation:Required
+	Spec HostPortExemptionSpec `json:"spec,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Status HostPortExemptionStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// HostPortExemptionList contains a list of HostPortExemption
+type HostPortExemptionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HostPortExemption `json:"items"`
+}
+
+// Matches returns true if the pod matches every criteria set on the exemption
+func (in *HostPortExemption) Matches(pod *v1.Pod) (bool, error) {
+	// an exemption without criteria would exempt every pod
+	if len(in.Spec.Namespaces) == 0 && len(in.Spec.ServiceAccounts) == 0 && in.Spec.PodSelector == nil {
+		return false, nil
+	}
+
+	if len(in.Spec.Namespaces) > 0 && slices.Contains(in.Spec.Namespaces, pod.Namespace) == false {
+		return false, nil
+	}
+
+	if len(in.Spec.ServiceAccounts) > 0 {
+		serviceAccountName := pod.Spec.ServiceAccountName
+		if len(serviceAccountName) == 0 {
+			serviceAccountName = "default"
+		}
+
+		if slices.Contains(in.Spec.ServiceAccounts, HostPortExemptionServiceAccount{Namespace: pod.Namespace, Name: serviceAccountName}) == false {
+			return false, nil
+		}
+	}
+
+	if in.Spec.PodSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(in.Spec.PodSelector)
+		if err != nil {
+			return false, err
+		}
+
+		if selector.Matches(labels.Set(pod.Labels)) == false {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Exempts returns true if the container port's host port is exempted
+func (in *HostPortExemptionPort) Exempts(port *v1.ContainerPort) bool {
+	protocol := port.Protocol
+	if len(protocol) == 0 {
+		protocol = v1.ProtocolTCP
+	}
+
+	return in.Port == int(port.HostPort) && (len(in.Protocol) == 0 || in.Protocol == protocol)
+}
+
+func init() {
+	SchemeBuilder.Register(&HostPortExemption{}, &HostPortExemptionList{})
+}
@@ -0,0 +1,107 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	intmetav1 "github.com/rmb938/hostport-allocator/apis/meta/v1"
+)
+
+const (
+	// HostPortNodeConditionReservedPortsSynced is true when the pool ports of all classes are
+	// reserved in the node's net.ipv4.ip_local_reserved_ports
+	HostPortNodeConditionReservedPortsSynced = "ReservedPortsSynced"
+
+	// HostPortNodeConditionPortConflicts is true when ports inside the pools of a HostPortClass are bound
+	// on the node by something other than the pods allocated the ports
+	HostPortNodeConditionPortConflicts = "PortConflicts"
+
+	// HostPortNodeConditionFirewallApplied is true when the firewall ruleset opening the allocated ports is applied
+	HostPortNodeConditionFirewallApplied = "FirewallApplied"
+)
+
+// EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
+// NOTE: json tags are required.  Any new fields you add must have json tags for the fields to be serialized.
+
+// HostPortNodeSpec defines the desired state of HostPortNode
+type HostPortNodeSpec struct {
+	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+}
+
+type HostPortNodeConflict struct {
+	// The conflicting port
+	Port int `json:"port"`
+
+	// The protocol of the socket bound to the port
+	Protocol v1.Protocol `json:"protocol"`
+}
+
+// HostPortNodeStatus defines the observed state of HostPortNode
+type HostPortNodeStatus struct {
+	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
+	// Important: Run "make" to regenerate code after modifying this file
+
+	// Resource status conditions
+	// +kubebuilder:validation:Optional
+	Conditions []intmetav1.Condition `json:"conditions,omitempty"`
+
+	// The ports the node agent reserved in net.ipv4.ip_local_reserved_ports
+	// +kubebuilder:validation:Optional
+	ReservedPorts string `json:"reservedPorts,omitempty"`
+
+	// Ports inside the pools of a HostPortClass bound by something other than the pods allocated the ports.
+	// Pods are not scheduled to the node with these ports.
+	// +kubebuilder:validation:Optional
+	Conflicts []HostPortNodeConflict `json:"conflicts,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=hpn
+// +kubebuilder:storageversion
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="RESERVED",type=string,JSONPath=`.status.conditions[?(@.type=="ReservedPortsSynced")].status`,priority=0
+// +kubebuilder:printcolumn:name="CONFLICTS",type=string,JSONPath=`.status.conditions[?(@.type=="PortConflicts")].status`,priority=0
+// +kubebuilder:printcolumn:name="AGE",type="date",JSONPath=".metadata.creationTimestamp"
+
+// HostPortNode is the Schema for the hostportnodes API.
+// It is created by the node agent running on the node with the same name and reports the state of the node.
+type HostPortNode struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Spec HostPortNodeSpec `json:"spec,omitempty"`
+
+	// +kubebuilder:validation:Optional
+	Status HostPortNodeStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// HostPortNodeList contains a list of HostPortNode
+type HostPortNodeList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []HostPortNode `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&HostPortNode{}, &HostPortNodeList{})
+}
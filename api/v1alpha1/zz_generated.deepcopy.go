@@ -24,6 +24,7 @@ package v1alpha1
 import (
 	metav1 "github.com/rmb938/hostport-allocator/apis/meta/v1"
 	"k8s.io/api/core/v1"
+	apismetav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -59,7 +60,7 @@ func (in *HostPortClaim) DeepCopyInto(out *HostPortClaim) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
 	in.Status.DeepCopyInto(&out.Status)
 }
 
@@ -81,6 +82,21 @@ func (in *HostPortClaim) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortClaimEndpoint) DeepCopyInto(out *HostPortClaimEndpoint) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortClaimEndpoint.
+func (in *HostPortClaimEndpoint) DeepCopy() *HostPortClaimEndpoint {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortClaimEndpoint)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HostPortClaimList) DeepCopyInto(out *HostPortClaimList) {
 	*out = *in
@@ -116,6 +132,16 @@ func (in *HostPortClaimList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HostPortClaimSpec) DeepCopyInto(out *HostPortClaimSpec) {
 	*out = *in
+	if in.FallbackHostPortClassNames != nil {
+		in, out := &in.FallbackHostPortClassNames, &out.FallbackHostPortClassNames
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ReleaseAfterUnused != nil {
+		in, out := &in.ReleaseAfterUnused, &out.ReleaseAfterUnused
+		*out = new(apismetav1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortClaimSpec.
@@ -138,6 +164,20 @@ func (in *HostPortClaimStatus) DeepCopyInto(out *HostPortClaimStatus) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.Users != nil {
+		in, out := &in.Users, &out.Users
+		*out = make([]v1.ObjectReference, len(*in))
+		copy(*out, *in)
+	}
+	if in.Endpoints != nil {
+		in, out := &in.Endpoints, &out.Endpoints
+		*out = make([]HostPortClaimEndpoint, len(*in))
+		copy(*out, *in)
+	}
+	if in.UnusedSince != nil {
+		in, out := &in.UnusedSince, &out.UnusedSince
+		*out = (*in).DeepCopy()
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortClaimStatus.
@@ -156,7 +196,7 @@ func (in *HostPortClass) DeepCopyInto(out *HostPortClass) {
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortClass.
@@ -215,8 +255,20 @@ func (in *HostPortClassSpec) DeepCopyInto(out *HostPortClassSpec) {
 	if in.Pools != nil {
 		in, out := &in.Pools, &out.Pools
 		*out = make([]HostPortClassSpecPool, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.AllowedNamespaces != nil {
+		in, out := &in.AllowedNamespaces, &out.AllowedNamespaces
+		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(apismetav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortClassSpec.
@@ -232,6 +284,11 @@ func (in *HostPortClassSpec) DeepCopy() *HostPortClassSpec {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HostPortClassSpecPool) DeepCopyInto(out *HostPortClassSpecPool) {
 	*out = *in
+	if in.NamespaceSelector != nil {
+		in, out := &in.NamespaceSelector, &out.NamespaceSelector
+		*out = new(apismetav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortClassSpecPool.
@@ -247,6 +304,11 @@ func (in *HostPortClassSpecPool) DeepCopy() *HostPortClassSpecPool {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HostPortClassStatus) DeepCopyInto(out *HostPortClassStatus) {
 	*out = *in
+	if in.Pools != nil {
+		in, out := &in.Pools, &out.Pools
+		*out = make([]HostPortClassStatusPool, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortClassStatus.
@@ -259,6 +321,160 @@ func (in *HostPortClassStatus) DeepCopy() *HostPortClassStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortClassStatusPool) DeepCopyInto(out *HostPortClassStatusPool) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortClassStatusPool.
+func (in *HostPortClassStatusPool) DeepCopy() *HostPortClassStatusPool {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortClassStatusPool)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortExemption) DeepCopyInto(out *HostPortExemption) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	out.Status = in.Status
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortExemption.
+func (in *HostPortExemption) DeepCopy() *HostPortExemption {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortExemption)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *HostPortExemption) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortExemptionList) DeepCopyInto(out *HostPortExemptionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]HostPortExemption, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortExemptionList.
+func (in *HostPortExemptionList) DeepCopy() *HostPortExemptionList {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortExemptionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *HostPortExemptionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortExemptionPort) DeepCopyInto(out *HostPortExemptionPort) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortExemptionPort.
+func (in *HostPortExemptionPort) DeepCopy() *HostPortExemptionPort {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortExemptionPort)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortExemptionServiceAccount) DeepCopyInto(out *HostPortExemptionServiceAccount) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortExemptionServiceAccount.
+func (in *HostPortExemptionServiceAccount) DeepCopy() *HostPortExemptionServiceAccount {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortExemptionServiceAccount)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortExemptionSpec) DeepCopyInto(out *HostPortExemptionSpec) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.ServiceAccounts != nil {
+		in, out := &in.ServiceAccounts, &out.ServiceAccounts
+		*out = make([]HostPortExemptionServiceAccount, len(*in))
+		copy(*out, *in)
+	}
+	if in.PodSelector != nil {
+		in, out := &in.PodSelector, &out.PodSelector
+		*out = new(apismetav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Ports != nil {
+		in, out := &in.Ports, &out.Ports
+		*out = make([]HostPortExemptionPort, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortExemptionSpec.
+func (in *HostPortExemptionSpec) DeepCopy() *HostPortExemptionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortExemptionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortExemptionStatus) DeepCopyInto(out *HostPortExemptionStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortExemptionStatus.
+func (in *HostPortExemptionStatus) DeepCopy() *HostPortExemptionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortExemptionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HostPortList) DeepCopyInto(out *HostPortList) {
 	*out = *in
@@ -291,6 +507,122 @@ func (in *HostPortList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortNode) DeepCopyInto(out *HostPortNode) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	out.Spec = in.Spec
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortNode.
+func (in *HostPortNode) DeepCopy() *HostPortNode {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortNode)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *HostPortNode) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortNodeConflict) DeepCopyInto(out *HostPortNodeConflict) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortNodeConflict.
+func (in *HostPortNodeConflict) DeepCopy() *HostPortNodeConflict {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortNodeConflict)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortNodeList) DeepCopyInto(out *HostPortNodeList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]HostPortNode, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortNodeList.
+func (in *HostPortNodeList) DeepCopy() *HostPortNodeList {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortNodeList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *HostPortNodeList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortNodeSpec) DeepCopyInto(out *HostPortNodeSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortNodeSpec.
+func (in *HostPortNodeSpec) DeepCopy() *HostPortNodeSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortNodeSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HostPortNodeStatus) DeepCopyInto(out *HostPortNodeStatus) {
+	*out = *in
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Conflicts != nil {
+		in, out := &in.Conflicts, &out.Conflicts
+		*out = make([]HostPortNodeConflict, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HostPortNodeStatus.
+func (in *HostPortNodeStatus) DeepCopy() *HostPortNodeStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(HostPortNodeStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *HostPortSpec) DeepCopyInto(out *HostPortSpec) {
 	*out = *in
@@ -0,0 +1,228 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+// allocatablePorts returns the ports the HostPort may be allocated in order of preference,
+// leaving the ports to the HostPorts queued before it
+func allocatablePorts(ctx context.Context, c client.Reader, log logr.Logger, hp *hostportv1alpha1.HostPort, hpcl *hostportv1alpha1.HostPortClass) ([]int, error) {
+	usedPorts, err := reservedPorts(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	queue, err := classQueue(ctx, c, hp.Spec.HostPortClassName)
+	if err != nil {
+		return nil, err
+	}
+
+	// HostPorts ahead in the queue are served first so the ports they would take are not available
+	var waiting []hostportv1alpha1.HostPort
+	for _, i := range queue {
+		if queuedBefore(&i, hp) {
+			waiting = append(waiting, i)
+		}
+	}
+	allocateQueue(ctx, c, log, waiting, hpcl, usedPorts)
+
+	return availablePorts(ctx, c, hp, hpcl, usedPorts)
+}
+
+// reservedPorts returns the ports allocated to HostPorts and the ports exempted for system workloads
+func reservedPorts(ctx context.Context, c client.Reader) (map[int]struct{}, error) {
+	hostPortList := &hostportv1alpha1.HostPortList{}
+	err := c.List(ctx, hostPortList)
+	if err != nil {
+		return nil, err
+	}
+
+	usedPorts := make(map[int]struct{})
+	for _, i := range hostPortList.Items {
+		if i.Status.Port > 0 {
+			usedPorts[i.Status.Port] = struct{}{}
+		}
+	}
+
+	hostPortExemptionList := &hostportv1alpha1.HostPortExemptionList{}
+	err = c.List(ctx, hostPortExemptionList)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range hostPortExemptionList.Items {
+		for _, port := range i.Spec.Ports {
+			usedPorts[port.Port] = struct{}{}
+		}
+	}
+
+	return usedPorts, nil
+}
+
+// availablePorts returns the ports the HostPort may be allocated in order of preference
+func availablePorts(ctx context.Context, c client.Reader, hp *hostportv1alpha1.HostPort, hpcl *hostportv1alpha1.HostPortClass, usedPorts map[int]struct{}) ([]int, error) {
+	poolTiers, err := allocationPools(ctx, c, hp, hpcl)
+	if err != nil {
+		return nil, err
+	}
+
+	availablePorts := make([]int, 0)
+	for _, pools := range poolTiers {
+		for _, pool := range pools {
+			for port := pool.Start; port <= pool.End; port++ {
+				if _, ok := usedPorts[port]; !ok {
+					availablePorts = append(availablePorts, port)
+				}
+			}
+		}
+
+		if len(availablePorts) > 0 {
+			break
+		}
+	}
+
+	for _, port := range availablePorts {
+		if hp.Spec.PreferredPort > 0 && port == hp.Spec.PreferredPort {
+			return []int{port}, nil
+		}
+	}
+
+	return availablePorts, nil
+}
+
+// allocateQueue returns the port each queued HostPort is allocated in queue order, marking the ports as used.
+// HostPorts without a free port are left out. HostPorts whose pools can't be resolved are skipped with a port of 0
+// so they don't hold up the rest of the queue.
+func allocateQueue(ctx context.Context, c client.Reader, log logr.Logger, queue []hostportv1alpha1.HostPort, hpcl *hostportv1alpha1.HostPortClass, usedPorts map[int]struct{}) map[string]int {
+	allocated := make(map[string]int)
+	for i := range queue {
+		ports, err := availablePorts(ctx, c, &queue[i], hpcl, usedPorts)
+		if err != nil {
+			log.Error(err, "skipping hostport with unresolvable pools", "hostport", queue[i].Name, "hostportclass", hpcl.Name)
+			allocated[queue[i].Name] = 0
+			continue
+		}
+
+		if len(ports) > 0 {
+			usedPorts[ports[0]] = struct{}{}
+			allocated[queue[i].Name] = ports[0]
+		}
+	}
+
+	return allocated
+}
+
+// classQueue returns the pending HostPorts of the class in the order they are allocated
+func classQueue(ctx context.Context, c client.Reader, hostPortClassName string) ([]hostportv1alpha1.HostPort, error) {
+	queue, err := pendingHostPorts(ctx, c, hostPortClassName)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(queue, func(i, j int) bool {
+		return queuedBefore(&queue[i], &queue[j])
+	})
+
+	return queue, nil
+}
+
+// pendingHostPorts returns the HostPorts of the class that are waiting for a port
+func pendingHostPorts(ctx context.Context, c client.Reader, hostPortClassName string) ([]hostportv1alpha1.HostPort, error) {
+	hostPortList := &hostportv1alpha1.HostPortList{}
+	err := c.List(ctx, hostPortList, client.MatchingFields{"spec.hostPortClassName": hostPortClassName})
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []hostportv1alpha1.HostPort
+	for _, i := range hostPortList.Items {
+		if i.DeletionTimestamp.IsZero() == false {
+			continue
+		}
+
+		if i.Status.Phase == hostportv1alpha1.HostPortPhasePending || len(i.Status.Phase) == 0 {
+			pending = append(pending, i)
+		}
+	}
+
+	return pending, nil
+}
+
+// queuedBefore returns true when a is allocated a port before b,
+// by highest priority first and then by the oldest
+func queuedBefore(a, b *hostportv1alpha1.HostPort) bool {
+	if a.Spec.Priority != b.Spec.Priority {
+		return a.Spec.Priority > b.Spec.Priority
+	}
+
+	if a.CreationTimestamp.Equal(&b.CreationTimestamp) == false {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+
+	return a.Name < b.Name
+}
+
+// allocationPools returns the pools of the class the HostPort may be allocated from,
+// grouped into tiers in order of preference
+func allocationPools(ctx context.Context, c client.Reader, hp *hostportv1alpha1.HostPort, hpcl *hostportv1alpha1.HostPortClass) ([][]hostportv1alpha1.HostPortClassSpecPool, error) {
+	// HostPorts whose claim namespace is unknown only use the shared pools
+	var namespaceLabels labels.Set
+	if hp.Spec.ClaimRef != nil && len(hp.Spec.ClaimRef.Namespace) > 0 {
+		ns := &corev1.Namespace{}
+		err := c.Get(ctx, types.NamespacedName{Name: hp.Spec.ClaimRef.Namespace}, ns)
+		if err != nil {
+			if apierrors.IsNotFound(err) == false {
+				return nil, err
+			}
+		} else {
+			namespaceLabels = labels.Set(ns.Labels)
+			if namespaceLabels == nil {
+				namespaceLabels = labels.Set{}
+			}
+		}
+	}
+
+	var dedicatedPools []hostportv1alpha1.HostPortClassSpecPool
+	var sharedPools []hostportv1alpha1.HostPortClassSpecPool
+	for _, pool := range hpcl.Spec.Pools {
+		if pool.NamespaceSelector == nil {
+			sharedPools = append(sharedPools, pool)
+			continue
+		}
+
+		// manually created HostPorts without a claim and claims from deleted namespaces can't use dedicated pools
+		if namespaceLabels == nil {
+			continue
+		}
+
+		selector, err := metav1.LabelSelectorAsSelector(pool.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+
+		if selector.Matches(namespaceLabels) {
+			dedicatedPools = append(dedicatedPools, pool)
+		}
+	}
+
+	if len(dedicatedPools) == 0 {
+		return [][]hostportv1alpha1.HostPortClassSpecPool{sharedPools}, nil
+	}
+
+	if hpcl.Spec.AllowSharedPoolFallback {
+		return [][]hostportv1alpha1.HostPortClassSpecPool{dedicatedPools, sharedPools}, nil
+	}
+
+	return [][]hostportv1alpha1.HostPortClassSpecPool{dedicatedPools}, nil
+}
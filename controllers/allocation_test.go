@@ -0,0 +1,330 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+func newFakeClient(objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = hostportv1alpha1.AddToScheme(scheme)
+
+	return fake.NewClientBuilder().
+		WithScheme(scheme).
+		WithObjects(objects...).
+		WithStatusSubresource(&hostportv1alpha1.HostPort{}, &hostportv1alpha1.HostPortClaim{}).
+		WithIndex(&hostportv1alpha1.HostPort{}, "spec.hostPortClassName", func(rawObj client.Object) []string {
+			return []string{rawObj.(*hostportv1alpha1.HostPort).Spec.HostPortClassName}
+		}).
+		WithIndex(&corev1.Pod{}, podClaimIndex, func(rawObj client.Object) []string {
+			return podClaims(rawObj.(*corev1.Pod))
+		}).
+		Build()
+}
+
+func TestQueuedBefore(t *testing.T) {
+	older := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	newer := metav1.NewTime(older.Add(time.Minute))
+
+	hostPort := func(name string, priority int, created metav1.Time) *hostportv1alpha1.HostPort {
+		return &hostportv1alpha1.HostPort{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: created},
+			Spec:       hostportv1alpha1.HostPortSpec{Priority: priority},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		a        *hostportv1alpha1.HostPort
+		b        *hostportv1alpha1.HostPort
+		expected bool
+	}{
+		{name: "older first", a: hostPort("a", 0, older), b: hostPort("b", 0, newer), expected: true},
+		{name: "newer after", a: hostPort("a", 0, newer), b: hostPort("b", 0, older), expected: false},
+		{name: "higher priority first", a: hostPort("a", 10, newer), b: hostPort("b", 0, older), expected: true},
+		{name: "lower priority after", a: hostPort("a", -1, older), b: hostPort("b", 0, newer), expected: false},
+		{name: "same age by name", a: hostPort("a", 0, older), b: hostPort("b", 0, older), expected: true},
+		{name: "itself", a: hostPort("a", 0, older), b: hostPort("a", 0, older), expected: false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if queuedBefore(test.a, test.b) != test.expected {
+				t.Errorf("expected queuedBefore to be %v", test.expected)
+			}
+		})
+	}
+}
+
+func TestAllocateQueue(t *testing.T) {
+	hpcl := &hostportv1alpha1.HostPortClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "class"},
+		Spec: hostportv1alpha1.HostPortClassSpec{
+			Pools: []hostportv1alpha1.HostPortClassSpecPool{
+				{Start: 9000, End: 9003},
+				// pools with an invalid selector can't be resolved for claimed HostPorts
+				{Start: 9100, End: 9100, NamespaceSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "team", Operator: "Bogus"},
+				}}},
+			},
+		},
+	}
+
+	hostPort := func(name string, preferredPort int) hostportv1alpha1.HostPort {
+		return hostportv1alpha1.HostPort{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       hostportv1alpha1.HostPortSpec{HostPortClassName: hpcl.Name, PreferredPort: preferredPort},
+		}
+	}
+
+	claimed := func(name string) hostportv1alpha1.HostPort {
+		hp := hostPort(name, 0)
+		hp.Spec.ClaimRef = &corev1.ObjectReference{Namespace: "default", Name: name}
+		return hp
+	}
+
+	tests := []struct {
+		name      string
+		usedPorts []int
+		queue     []hostportv1alpha1.HostPort
+		expected  map[string]int
+	}{
+		{
+			name:     "no waiting",
+			expected: map[string]int{},
+		},
+		{
+			name:      "in queue order",
+			usedPorts: []int{9000},
+			queue:     []hostportv1alpha1.HostPort{hostPort("a", 0), hostPort("b", 0)},
+			expected:  map[string]int{"a": 9001, "b": 9002},
+		},
+		{
+			name:     "preferred ports",
+			queue:    []hostportv1alpha1.HostPort{hostPort("a", 9003), hostPort("b", 9003)},
+			expected: map[string]int{"a": 9003, "b": 9000},
+		},
+		{
+			name:      "more waiting than free ports",
+			usedPorts: []int{9000, 9001},
+			queue:     []hostportv1alpha1.HostPort{hostPort("a", 0), hostPort("b", 0), hostPort("c", 0)},
+			expected:  map[string]int{"a": 9002, "b": 9003},
+		},
+		{
+			name:     "unresolvable pools are skipped",
+			queue:    []hostportv1alpha1.HostPort{claimed("a"), hostPort("b", 0)},
+			expected: map[string]int{"a": 0, "b": 9000},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			usedPorts := make(map[int]struct{})
+			for _, port := range test.usedPorts {
+				usedPorts[port] = struct{}{}
+			}
+
+			c := newFakeClient(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}})
+
+			allocated := allocateQueue(context.Background(), c, logr.Discard(), test.queue, hpcl, usedPorts)
+			if reflect.DeepEqual(allocated, test.expected) == false {
+				t.Errorf("unexpected allocated ports %v", allocated)
+			}
+
+			for name, port := range allocated {
+				if _, ok := usedPorts[port]; port > 0 && !ok {
+					t.Errorf("port %d allocated to %s is not marked as used", port, name)
+				}
+			}
+		})
+	}
+}
+
+func TestAllocatablePorts(t *testing.T) {
+	older := metav1.NewTime(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	newer := metav1.NewTime(older.Add(time.Minute))
+
+	hpcl := &hostportv1alpha1.HostPortClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "class"},
+		Spec: hostportv1alpha1.HostPortClassSpec{
+			Pools: []hostportv1alpha1.HostPortClassSpecPool{{Start: 9000, End: 9003}},
+		},
+	}
+
+	exemption := func(ports ...int) *hostportv1alpha1.HostPortExemption {
+		hpe := &hostportv1alpha1.HostPortExemption{
+			ObjectMeta: metav1.ObjectMeta{Name: "exemption"},
+			Spec:       hostportv1alpha1.HostPortExemptionSpec{Namespaces: []string{"kube-system"}},
+		}
+		for _, port := range ports {
+			hpe.Spec.Ports = append(hpe.Spec.Ports, hostportv1alpha1.HostPortExemptionPort{Port: port, Protocol: corev1.ProtocolTCP})
+		}
+		return hpe
+	}
+
+	hostPort := func(name string, created metav1.Time, port int) *hostportv1alpha1.HostPort {
+		hp := &hostportv1alpha1.HostPort{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: created},
+			Spec:       hostportv1alpha1.HostPortSpec{HostPortClassName: hpcl.Name},
+		}
+		if port > 0 {
+			hp.Status.Phase = hostportv1alpha1.HostPortPhaseAllocated
+			hp.Status.Port = port
+		}
+		return hp
+	}
+
+	tests := []struct {
+		name          string
+		objects       []client.Object
+		preferredPort int
+		expected      []int
+	}{
+		{
+			name:     "all free",
+			expected: []int{9000, 9001, 9002, 9003},
+		},
+		{
+			name:     "exempted ports are not allocated",
+			objects:  []client.Object{exemption(9000, 9002)},
+			expected: []int{9001, 9003},
+		},
+		{
+			name:          "exempted preferred port",
+			objects:       []client.Object{exemption(9002)},
+			preferredPort: 9002,
+			expected:      []int{9000, 9001, 9003},
+		},
+		{
+			name:     "allocated ports are not allocated",
+			objects:  []client.Object{hostPort("allocated", older, 9001)},
+			expected: []int{9000, 9002, 9003},
+		},
+		{
+			name:     "ports are left to waiting HostPorts ahead",
+			objects:  []client.Object{exemption(9000), hostPort("waiting", older, 0)},
+			expected: []int{9002, 9003},
+		},
+		{
+			name:     "exempted ports fill the pool",
+			objects:  []client.Object{exemption(9000, 9001, 9002, 9003)},
+			expected: []int{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newFakeClient(append([]client.Object{hpcl}, test.objects...)...)
+
+			hp := hostPort("hostport", newer, 0)
+			hp.Spec.PreferredPort = test.preferredPort
+
+			ports, err := allocatablePorts(context.Background(), c, logr.Discard(), hp, hpcl)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reflect.DeepEqual(ports, test.expected) == false {
+				t.Errorf("unexpected allocatable ports %v", ports)
+			}
+		})
+	}
+}
+
+func TestAllocationPools(t *testing.T) {
+	shared := hostportv1alpha1.HostPortClassSpecPool{Name: "shared", Start: 9000, End: 9009}
+	teamA := hostportv1alpha1.HostPortClassSpecPool{Name: "team-a", Start: 9100, End: 9109,
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}}}
+	everyone := hostportv1alpha1.HostPortClassSpecPool{Name: "everyone", Start: 9200, End: 9209,
+		NamespaceSelector: &metav1.LabelSelector{}}
+
+	namespaces := []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "a"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-b", Labels: map[string]string{"team": "b"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "unlabeled"}},
+	}
+
+	tests := []struct {
+		name      string
+		pools     []hostportv1alpha1.HostPortClassSpecPool
+		fallback  bool
+		namespace string
+		expected  [][]hostportv1alpha1.HostPortClassSpecPool
+	}{
+		{
+			name:      "dedicated pools",
+			pools:     []hostportv1alpha1.HostPortClassSpecPool{shared, teamA},
+			namespace: "team-a",
+			expected:  [][]hostportv1alpha1.HostPortClassSpecPool{{teamA}},
+		},
+		{
+			name:      "dedicated pools with shared fallback",
+			pools:     []hostportv1alpha1.HostPortClassSpecPool{shared, teamA},
+			fallback:  true,
+			namespace: "team-a",
+			expected:  [][]hostportv1alpha1.HostPortClassSpecPool{{teamA}, {shared}},
+		},
+		{
+			name:      "no matching dedicated pools",
+			pools:     []hostportv1alpha1.HostPortClassSpecPool{shared, teamA},
+			namespace: "team-b",
+			expected:  [][]hostportv1alpha1.HostPortClassSpecPool{{shared}},
+		},
+		{
+			name:      "unlabeled namespace matches an empty selector",
+			pools:     []hostportv1alpha1.HostPortClassSpecPool{shared, teamA, everyone},
+			namespace: "unlabeled",
+			expected:  [][]hostportv1alpha1.HostPortClassSpecPool{{everyone}},
+		},
+		{
+			name:     "no claim",
+			pools:    []hostportv1alpha1.HostPortClassSpecPool{shared, teamA, everyone},
+			expected: [][]hostportv1alpha1.HostPortClassSpecPool{{shared}},
+		},
+		{
+			name:      "deleted namespace",
+			pools:     []hostportv1alpha1.HostPortClassSpecPool{shared, teamA, everyone},
+			namespace: "deleted",
+			expected:  [][]hostportv1alpha1.HostPortClassSpecPool{{shared}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hpcl := &hostportv1alpha1.HostPortClass{
+				ObjectMeta: metav1.ObjectMeta{Name: "class"},
+				Spec:       hostportv1alpha1.HostPortClassSpec{Pools: test.pools, AllowSharedPoolFallback: test.fallback},
+			}
+
+			hp := &hostportv1alpha1.HostPort{
+				ObjectMeta: metav1.ObjectMeta{Name: "hostport"},
+				Spec:       hostportv1alpha1.HostPortSpec{HostPortClassName: hpcl.Name},
+			}
+			if len(test.namespace) > 0 {
+				hp.Spec.ClaimRef = &corev1.ObjectReference{Namespace: test.namespace, Name: "claim"}
+			}
+
+			pools, err := allocationPools(context.Background(), newFakeClient(namespaces...), hp, hpcl)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reflect.DeepEqual(pools, test.expected) == false {
+				t.Errorf("unexpected pools %v", pools)
+			}
+		})
+	}
+}
@@ -20,31 +20,45 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/rmb938/hostport-allocator/api/meta"
 	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+	intmetav1 "github.com/rmb938/hostport-allocator/apis/meta/v1"
+	"github.com/rmb938/hostport-allocator/metrics"
 )
 
 // HostPortReconciler reconciles a HostPort object
 type HostPortReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 
 	allocationLock sync.Mutex
 }
 
 // +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostports,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostports/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportexemptions,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *HostPortReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = r.Log.WithValues("hostport", req.NamespacedName)
@@ -87,8 +101,9 @@ func (r *HostPortReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 			}
 
 			if hpc != nil {
-				if hpc.UID == hp.Spec.ClaimRef.UID {
-					// can't delete because claimref hpc exists
+				// can't delete because claimref hpc exists, unless the hpc released the port
+				if hpc.UID == hp.Spec.ClaimRef.UID && hpc.Status.Phase != hostportv1alpha1.HostPortClaimPhaseReleased {
+					r.Recorder.Eventf(hp, corev1.EventTypeWarning, "DeletionBlocked", "HostPort is bound to HostPortClaim %s/%s", hpc.Namespace, hpc.Name)
 					return ctrl.Result{}, nil
 				}
 			}
@@ -102,6 +117,10 @@ func (r *HostPortReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		if err != nil {
 			return ctrl.Result{}, err
 		}
+
+		if hp.Status.Port > 0 {
+			r.Recorder.Eventf(hp, corev1.EventTypeNormal, "Released", "Released port %d of HostPortClass %s", hp.Status.Port, hp.Spec.HostPortClassName)
+		}
 		return ctrl.Result{}, nil
 	}
 
@@ -120,7 +139,8 @@ func (r *HostPortReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		err := r.Get(ctx, types.NamespacedName{Name: hp.Spec.HostPortClassName}, hpcl)
 		if err != nil {
 			if apierrors.IsNotFound(err) {
-				// TODO: event saying can't find host port class
+				r.Recorder.Eventf(hp, corev1.EventTypeWarning, "ClassNotFound", "HostPortClass %s does not exist", hp.Spec.HostPortClassName)
+				metrics.AllocationFailures.WithLabelValues(hp.Spec.HostPortClassName, metrics.AllocationFailureClassNotFound).Inc()
 			}
 
 			return ctrl.Result{}, err
@@ -129,42 +149,34 @@ func (r *HostPortReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 		r.allocationLock.Lock()
 		defer r.allocationLock.Unlock()
 
-		// get all ports
-		hostPortList := &hostportv1alpha1.HostPortList{}
-		err = r.List(ctx, hostPortList)
+		usedPorts, err := reservedPorts(ctx, r.Client)
 		if err != nil {
+			metrics.AllocationFailures.WithLabelValues(hpcl.Name, metrics.AllocationFailureError).Inc()
 			return ctrl.Result{}, err
 		}
 
-		usedPorts := make(map[int]struct{})
-		for _, i := range hostPortList.Items {
-			if i.Status.Port > 0 {
-				usedPorts[i.Status.Port] = struct{}{}
-			}
+		queue, err := classQueue(ctx, r.Client, hpcl.Name)
+		if err != nil {
+			metrics.AllocationFailures.WithLabelValues(hpcl.Name, metrics.AllocationFailureError).Inc()
+			return ctrl.Result{}, err
 		}
 
-		availablePorts := make([]int, 0)
-		for _, pool := range hpcl.Spec.Pools {
-			for port := pool.Start; port <= pool.End; port++ {
-				if _, ok := usedPorts[port]; !ok {
-					availablePorts = append(availablePorts, port)
-				}
+		// the whole queue of the class is served at once instead of every HostPort working out the queue ahead of it
+		allocated := allocateQueue(ctx, r.Client, r.Log, queue, hpcl, usedPorts)
+		for i := range queue {
+			waiter := &queue[i]
+			port, ok := allocated[waiter.Name]
+			switch {
+			case ok == false:
+				err = r.wait(ctx, waiter, hpcl)
+			case port > 0:
+				err = r.allocate(ctx, waiter, hpcl, port)
+			}
+			if err != nil {
+				return ctrl.Result{}, err
 			}
 		}
 
-		if len(availablePorts) == 0 {
-			// TODO: event saying can't find any ports
-
-			err := fmt.Errorf("no free ports to allocate")
-			return ctrl.Result{}, err
-		}
-
-		hp.Status.Port = availablePorts[0]
-		hp.Status.Phase = hostportv1alpha1.HostPortPhaseAllocated
-		err = r.Status().Update(ctx, hp)
-		if err != nil {
-			return ctrl.Result{}, err
-		}
 		return ctrl.Result{}, nil
 	}
 
@@ -185,7 +197,11 @@ func (r *HostPortReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 			}
 
 			// my hpc is gone or different so delete me
+			// HostPortOwnerReconciler normally handles this for dynamically provisioned HostPorts,
+			// this is a backstop for HostPorts without ownership labels
 			if hpc == nil || hpc.UID != hp.Spec.ClaimRef.UID {
+				r.Recorder.Eventf(hp, corev1.EventTypeNormal, "ClaimDeleted", "Deleting because HostPortClaim %s/%s no longer exists",
+					hp.Spec.ClaimRef.Namespace, hp.Spec.ClaimRef.Name)
 				err := r.Delete(ctx, hp)
 				if err != nil {
 					return ctrl.Result{}, err
@@ -197,6 +213,51 @@ func (r *HostPortReconciler) Reconcile(ctx context.Context, req ctrl.Request) (c
 	return ctrl.Result{}, nil
 }
 
+// allocate allocates the port to the waiting HostPort
+func (r *HostPortReconciler) allocate(ctx context.Context, hp *hostportv1alpha1.HostPort, hpcl *hostportv1alpha1.HostPortClass, port int) error {
+	if meta.FindStatusCondition(hp.Status.Conditions, hostportv1alpha1.HostPortConditionWaiting) != nil {
+		meta.SetStatusCondition(&hp.Status.Conditions, intmetav1.Condition{
+			Type:    hostportv1alpha1.HostPortConditionWaiting,
+			Status:  intmetav1.ConditionFalse,
+			Reason:  "Allocated",
+			Message: "A port was allocated",
+		})
+	}
+
+	hp.Status.Port = port
+	hp.Status.Phase = hostportv1alpha1.HostPortPhaseAllocated
+	err := r.Status().Update(ctx, hp)
+	if err != nil {
+		return err
+	}
+	metrics.AllocationDuration.WithLabelValues(hpcl.Name).Observe(time.Since(hp.CreationTimestamp.Time).Seconds())
+	r.Recorder.Eventf(hp, corev1.EventTypeNormal, "Allocated", "Allocated port %d from HostPortClass %s", hp.Status.Port, hpcl.Name)
+	return nil
+}
+
+// wait marks the HostPort as waiting until a port is released or the class gains capacity
+func (r *HostPortReconciler) wait(ctx context.Context, hp *hostportv1alpha1.HostPort, hpcl *hostportv1alpha1.HostPortClass) error {
+	if meta.IsStatusConditionTrue(hp.Status.Conditions, hostportv1alpha1.HostPortConditionWaiting) == false {
+		r.Recorder.Eventf(hp, corev1.EventTypeWarning, "Exhausted", "No free ports to allocate in HostPortClass %s", hpcl.Name)
+		metrics.AllocationFailures.WithLabelValues(hpcl.Name, metrics.AllocationFailureNoFreePorts).Inc()
+	}
+
+	status := hp.Status.DeepCopy()
+	meta.SetStatusCondition(&status.Conditions, intmetav1.Condition{
+		Type:    hostportv1alpha1.HostPortConditionWaiting,
+		Status:  intmetav1.ConditionTrue,
+		Reason:  "ClassExhausted",
+		Message: fmt.Sprintf("No free ports in HostPortClass %s", hpcl.Name),
+	})
+
+	if equality.Semantic.DeepEqual(&hp.Status, status) == false {
+		hp.Status = *status
+		return r.Status().Update(ctx, hp)
+	}
+
+	return nil
+}
+
 func (r *HostPortReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &hostportv1alpha1.HostPort{}, "spec.hostPortClassName", func(rawObj client.Object) []string {
 		hp := rawObj.(*hostportv1alpha1.HostPort)
@@ -205,8 +266,49 @@ func (r *HostPortReconciler) SetupWithManager(mgr ctrl.Manager) error {
 		return err
 	}
 
+	// requeue the head of the class queue when it may have gained capacity, serving the head serves the whole queue
+	classWaiters := func(ctx context.Context, hostPortClassName string, exclude string) []reconcile.Request {
+		queue, err := classQueue(ctx, r.Client, hostPortClassName)
+		if err != nil {
+			r.Log.Error(err, "error listing pending hostports", "hostportclass", hostPortClassName)
+			return nil
+		}
+
+		for _, i := range queue {
+			if i.Name == exclude {
+				continue
+			}
+			return []reconcile.Request{{NamespacedName: types.NamespacedName{Name: i.Name}}}
+		}
+		return nil
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&hostportv1alpha1.HostPort{}).
+		// only spec changes can add capacity, the class status changes with every allocation
+		Watches(&hostportv1alpha1.HostPortClass{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, object client.Object) []reconcile.Request {
+			return classWaiters(ctx, object.GetName(), "")
+		}), builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// released ports and HostPorts leaving the queue let the next HostPorts be allocated
+		Watches(&hostportv1alpha1.HostPort{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, object client.Object) []reconcile.Request {
+			hp := object.(*hostportv1alpha1.HostPort)
+			return classWaiters(ctx, hp.Spec.HostPortClassName, hp.Name)
+		}), builder.WithPredicates(predicate.Funcs{
+			CreateFunc: func(e event.CreateEvent) bool {
+				return false
+			},
+			UpdateFunc: func(e event.UpdateEvent) bool {
+				oldHP := e.ObjectOld.(*hostportv1alpha1.HostPort)
+				newHP := e.ObjectNew.(*hostportv1alpha1.HostPort)
+				return oldHP.Status.Port > 0 && newHP.Status.Port == 0
+			},
+			DeleteFunc: func(e event.DeleteEvent) bool {
+				return true
+			},
+			GenericFunc: func(e event.GenericEvent) bool {
+				return false
+			},
+		})).
 		Watches(&hostportv1alpha1.HostPortClaim{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, object client.Object) []reconcile.Request {
 			hpc := object.(*hostportv1alpha1.HostPortClaim)
 			var req []reconcile.Request
@@ -0,0 +1,62 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/rmb938/hostport-allocator/api/meta"
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+func TestHostPortReconcileQueue(t *testing.T) {
+	created := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	hpcl := &hostportv1alpha1.HostPortClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "class"},
+		Spec: hostportv1alpha1.HostPortClassSpec{
+			Pools: []hostportv1alpha1.HostPortClassSpecPool{{Start: 9000, End: 9001}},
+		},
+	}
+
+	hostPort := func(name string, age int) *hostportv1alpha1.HostPort {
+		return &hostportv1alpha1.HostPort{
+			ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(created.Add(time.Duration(age) * time.Minute))},
+			Spec:       hostportv1alpha1.HostPortSpec{HostPortClassName: hpcl.Name},
+			Status:     hostportv1alpha1.HostPortStatus{Phase: hostportv1alpha1.HostPortPhasePending},
+		}
+	}
+
+	c := newFakeClient(hpcl, hostPort("a", 0), hostPort("b", 1), hostPort("c", 2))
+	r := &HostPortReconciler{Client: c, Recorder: record.NewFakeRecorder(10)}
+
+	// reconciling the newest HostPort serves the whole queue of the class
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Name: "c"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]int{"a": 9000, "b": 9001, "c": 0}
+	for name, port := range expected {
+		hp := &hostportv1alpha1.HostPort{}
+		err := c.Get(context.Background(), client.ObjectKey{Name: name}, hp)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if hp.Status.Port != port {
+			t.Errorf("expected %s to be allocated port %d but got %d", name, port, hp.Status.Port)
+		}
+
+		waiting := meta.IsStatusConditionTrue(hp.Status.Conditions, hostportv1alpha1.HostPortConditionWaiting)
+		if waiting != (port == 0) {
+			t.Errorf("expected %s waiting to be %v", name, port == 0)
+		}
+	}
+}
@@ -19,33 +19,48 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
+	"github.com/rmb938/hostport-allocator/api/meta"
 	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+	intmetav1 "github.com/rmb938/hostport-allocator/apis/meta/v1"
 )
 
+// podClaimIndex indexes pods by the claims referenced in their annotations
+const podClaimIndex = "metadata.annotations.claims"
+
+// maxClaimUsers caps the pods listed in a claim's status so claims used by many pods stay small
+const maxClaimUsers = 100
+
 // HostPortClaimReconciler reconciles a HostPortClaim object
 type HostPortClaimReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportclaims,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportclaims/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportclasses,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *HostPortClaimReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = r.Log.WithValues("hostportclaim", req.NamespacedName)
@@ -57,6 +72,28 @@ func (r *HostPortClaimReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, err
 	}
 
+	users, endpoints, err := r.claimUsers(ctx, hpc)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	userCount := len(users)
+	if userCount > maxClaimUsers {
+		users = users[:maxClaimUsers]
+	}
+
+	if hpc.Status.UserCount != userCount || equality.Semantic.DeepEqual(hpc.Status.Users, users) == false ||
+		equality.Semantic.DeepEqual(hpc.Status.Endpoints, endpoints) == false {
+		hpc.Status.Users = users
+		hpc.Status.UserCount = userCount
+		hpc.Status.Endpoints = endpoints
+		err = r.Status().Update(ctx, hpc)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
 	if hpc.DeletionTimestamp.IsZero() == false {
 		// is deleting but the phase isn't deleting so set it
 		if hpc.Status.Phase != hostportv1alpha1.HostPortClaimPhaseDeleting {
@@ -73,26 +110,9 @@ func (r *HostPortClaimReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		}
 
 		// don't allow deletion when in use
-		podList := &corev1.PodList{}
-		err := r.List(ctx, podList, client.InNamespace(hpc.Namespace))
-		if err != nil {
-			return ctrl.Result{}, err
-		}
-
-		if len(podList.Items) > 0 {
-			for _, pod := range podList.Items {
-				if pod.Annotations == nil {
-					continue
-				}
-
-				for annotation, value := range pod.Annotations {
-					if strings.HasPrefix(annotation, hostportv1alpha1.HostPortPodAnnotationClaimPrefix+"/") {
-						if value == hpc.Name {
-							return ctrl.Result{}, nil
-						}
-					}
-				}
-			}
+		if hpc.Status.UserCount > 0 {
+			r.Recorder.Eventf(hpc, corev1.EventTypeWarning, "DeletionBlocked", "Deletion is blocked by %d pods using the claim", hpc.Status.UserCount)
+			return ctrl.Result{}, nil
 		}
 
 		// remove the finalizer
@@ -118,9 +138,15 @@ func (r *HostPortClaimReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 	if hpc.Status.Phase == hostportv1alpha1.HostPortClaimPhasePending {
 
 		if len(hpc.Spec.HostPortName) == 0 {
+			hostPortClassName, err := r.provisionClass(ctx, hpc)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+
 			hp := &hostportv1alpha1.HostPort{
 				ObjectMeta: metav1.ObjectMeta{
-					Name: fmt.Sprintf("hpc-%s", hpc.UID),
+					Name:   fmt.Sprintf("hpc-%s", hpc.UID),
+					Labels: claimOwnerLabels(hpc),
 				},
 				Spec: hostportv1alpha1.HostPortSpec{
 					ClaimRef: &corev1.ObjectReference{
@@ -128,15 +154,18 @@ func (r *HostPortClaimReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 						Name:      hpc.Name,
 						UID:       hpc.UID,
 					},
-					HostPortClassName: hpc.Spec.HostPortClassName,
+					HostPortClassName: hostPortClassName,
+					Priority:          hpc.Spec.Priority,
 				},
 			}
 
-			err := r.Create(ctx, hp)
+			err = r.Create(ctx, hp)
 			if err != nil {
 				if apierrors.IsAlreadyExists(err) == false {
 					return ctrl.Result{}, err
 				}
+			} else {
+				r.Recorder.Eventf(hpc, corev1.EventTypeNormal, "Provisioned", "Provisioned HostPort %s from HostPortClass %s", hp.Name, hostPortClassName)
 			}
 
 			hpc.Spec.HostPortName = fmt.Sprintf("hpc-%s", hpc.UID)
@@ -150,43 +179,354 @@ func (r *HostPortClaimReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		hp := &hostportv1alpha1.HostPort{}
 		err := r.Get(ctx, types.NamespacedName{Name: hpc.Spec.HostPortName}, hp)
 		if err != nil {
-			if apierrors.IsAlreadyExists(err) {
-				// TODO: event saying can't find hostport
+			if apierrors.IsNotFound(err) {
+				r.Recorder.Eventf(hpc, corev1.EventTypeWarning, "HostPortNotFound", "HostPort %s does not exist", hpc.Spec.HostPortName)
 			}
 			return ctrl.Result{}, err
 		}
 
 		hpc.Status.Phase = hostportv1alpha1.HostPortClaimPhaseBound
+		hpc.Status.HostPortClassName = hp.Spec.HostPortClassName
+		err = r.Status().Update(ctx, hpc)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		if hp.Status.Port > 0 {
+			r.Recorder.Eventf(hpc, corev1.EventTypeNormal, "Bound", "Bound to HostPort %s", hp.Name)
+		} else {
+			r.Recorder.Eventf(hpc, corev1.EventTypeNormal, "WaitingForPort", "Bound to HostPort %s which is waiting for a free port in HostPortClass %s",
+				hp.Name, hp.Spec.HostPortClassName)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if hpc.Status.Phase == hostportv1alpha1.HostPortClaimPhaseBound {
+		hp := &hostportv1alpha1.HostPort{}
+		err := r.Get(ctx, types.NamespacedName{Name: hpc.Spec.HostPortName}, hp)
+		if err != nil {
+			if apierrors.IsNotFound(err) == false {
+				return ctrl.Result{}, err
+			}
+
+			r.Recorder.Eventf(hpc, corev1.EventTypeWarning, "HostPortLost", "HostPort %s no longer exists", hpc.Spec.HostPortName)
+
+			hpc.Status.Phase = hostportv1alpha1.HostPortClaimPhaseLost
+			meta.SetStatusCondition(&hpc.Status.Conditions, intmetav1.Condition{
+				Type:    hostportv1alpha1.HostPortClaimConditionLost,
+				Status:  intmetav1.ConditionTrue,
+				Reason:  "HostPortNotFound",
+				Message: fmt.Sprintf("HostPort %s no longer exists", hpc.Spec.HostPortName),
+			})
+			err = r.Status().Update(ctx, hpc)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+
+		// claims bound before the class was recorded
+		if hpc.Status.HostPortClassName != hp.Spec.HostPortClassName {
+			hpc.Status.HostPortClassName = hp.Spec.HostPortClassName
+			err = r.Status().Update(ctx, hpc)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+
+		if hp.Status.Port > 0 && hp.Status.Port != hpc.Status.Port {
+			if hpc.Status.Port > 0 {
+				r.Recorder.Eventf(hpc, corev1.EventTypeNormal, "PortChanged", "Port changed from %d to %d", hpc.Status.Port, hp.Status.Port)
+			} else {
+				r.Recorder.Eventf(hpc, corev1.EventTypeNormal, "Allocated", "Allocated port %d", hp.Status.Port)
+			}
+
+			hpc.Status.Port = hp.Status.Port
+			err = r.Status().Update(ctx, hpc)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+
+		if hpc.Spec.ReleaseAfterUnused != nil {
+			return r.releaseUnused(ctx, hpc)
+		}
+	}
+
+	if hpc.Status.Phase == hostportv1alpha1.HostPortClaimPhaseLost {
+		hp := &hostportv1alpha1.HostPort{}
+		err := r.Get(ctx, types.NamespacedName{Name: hpc.Spec.HostPortName}, hp)
+		if err != nil {
+			if apierrors.IsNotFound(err) == false {
+				return ctrl.Result{}, err
+			}
+			hp = nil
+		}
+
+		// the HostPort is back so go through binding again
+		if hp != nil {
+			hpc.Status.Phase = hostportv1alpha1.HostPortClaimPhasePending
+			meta.SetStatusCondition(&hpc.Status.Conditions, intmetav1.Condition{
+				Type:    hostportv1alpha1.HostPortClaimConditionLost,
+				Status:  intmetav1.ConditionFalse,
+				Reason:  "HostPortFound",
+				Message: fmt.Sprintf("HostPort %s exists", hpc.Spec.HostPortName),
+			})
+			err = r.Status().Update(ctx, hpc)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+			return ctrl.Result{}, nil
+		}
+
+		if hpc.Spec.LostRecoveryPolicy == hostportv1alpha1.HostPortClaimLostRecoveryPolicyReprovision {
+			hp = &hostportv1alpha1.HostPort{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   hpc.Spec.HostPortName,
+					Labels: claimOwnerLabels(hpc),
+				},
+				Spec: hostportv1alpha1.HostPortSpec{
+					ClaimRef: &corev1.ObjectReference{
+						Namespace: hpc.Namespace,
+						Name:      hpc.Name,
+						UID:       hpc.UID,
+					},
+					HostPortClassName: hpc.BoundHostPortClassName(),
+					PreferredPort:     hpc.Status.Port,
+					Priority:          hpc.Spec.Priority,
+				},
+			}
+
+			err := r.Create(ctx, hp)
+			if err != nil {
+				if apierrors.IsAlreadyExists(err) == false {
+					return ctrl.Result{}, err
+				}
+				return ctrl.Result{}, nil
+			}
+
+			r.Recorder.Eventf(hpc, corev1.EventTypeNormal, "Reprovisioned", "Provisioned HostPort %s to replace the lost HostPort", hp.Name)
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// claimUsers returns references to the pods that use the claim sorted by name and the endpoints they are reachable on
+func (r *HostPortClaimReconciler) claimUsers(ctx context.Context, hpc *hostportv1alpha1.HostPortClaim) ([]corev1.ObjectReference, []hostportv1alpha1.HostPortClaimEndpoint, error) {
+	podList := &corev1.PodList{}
+	err := r.List(ctx, podList, client.InNamespace(hpc.Namespace), client.MatchingFields{podClaimIndex: hpc.Name})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sort.Slice(podList.Items, func(i, j int) bool {
+		return podList.Items[i].Name < podList.Items[j].Name
+	})
+
+	var users []corev1.ObjectReference
+	var endpoints []hostportv1alpha1.HostPortClaimEndpoint
+	for _, pod := range podList.Items {
+		users = append(users, corev1.ObjectReference{
+			Kind:      "Pod",
+			Namespace: pod.Namespace,
+			Name:      pod.Name,
+			UID:       pod.UID,
+		})
+
+		// pods are only reachable once scheduled and given the port
+		port := podClaimPort(&pod, hpc.Name)
+		if port == nil || len(pod.Status.HostIP) == 0 {
+			continue
+		}
+
+		endpoints = append(endpoints, hostportv1alpha1.HostPortClaimEndpoint{
+			Pod:    pod.Name,
+			Node:   pod.Spec.NodeName,
+			NodeIP: pod.Status.HostIP,
+			Port:   port.port,
+			Ready:  podReady(&pod),
+		})
+	}
+
+	return users, endpoints, nil
+}
+
+// podClaims returns the names of the claims referenced by the pod's annotations
+func podClaims(pod *corev1.Pod) []string {
+	var claims []string
+
+	for annotation, value := range pod.Annotations {
+		if strings.HasPrefix(annotation, hostportv1alpha1.HostPortPodAnnotationClaimPrefix+"/") {
+			claims = append(claims, value)
+		}
+	}
+
+	return claims
+}
+
+// podClaimRequests maps a pod to the claims it references
+func podClaimRequests(ctx context.Context, object client.Object) []reconcile.Request {
+	pod := object.(*corev1.Pod)
+	var req []reconcile.Request
+
+	for _, claim := range podClaims(pod) {
+		req = append(req, reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Namespace: pod.Namespace,
+				Name:      claim,
+			},
+		})
+	}
+
+	return req
+}
+
+// releaseUnused tracks how long a bound claim has been unused and releases it
+// once it has been unused for longer than releaseAfterUnused
+func (r *HostPortClaimReconciler) releaseUnused(ctx context.Context, hpc *hostportv1alpha1.HostPortClaim) (ctrl.Result, error) {
+	if hpc.Status.UserCount > 0 {
+		if hpc.Status.UnusedSince != nil {
+			hpc.Status.UnusedSince = nil
+			err := r.Status().Update(ctx, hpc)
+			if err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if hpc.Status.UnusedSince == nil {
+		now := metav1.Now()
+		hpc.Status.UnusedSince = &now
+		err := r.Status().Update(ctx, hpc)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+
+		action := "deleted"
+		if hpc.Spec.UnusedReleasePolicy == hostportv1alpha1.HostPortClaimUnusedReleasePolicyRelease {
+			action = "released"
+		}
+		releaseAt := now.Add(hpc.Spec.ReleaseAfterUnused.Duration)
+		r.Recorder.Eventf(hpc, corev1.EventTypeNormal, "Unused", "No pods use the claim, it will be %s at %s unless a pod uses it",
+			action, releaseAt.UTC().Format(time.RFC3339))
+		return ctrl.Result{RequeueAfter: hpc.Spec.ReleaseAfterUnused.Duration}, nil
+	}
+
+	remaining := hpc.Spec.ReleaseAfterUnused.Duration - time.Since(hpc.Status.UnusedSince.Time)
+	if remaining > 0 {
+		return ctrl.Result{RequeueAfter: remaining}, nil
+	}
+
+	if hpc.Spec.UnusedReleasePolicy == hostportv1alpha1.HostPortClaimUnusedReleasePolicyRelease {
+		r.Recorder.Eventf(hpc, corev1.EventTypeNormal, "Releasing", "Releasing hostPort %s, claim has been unused for %s", hpc.Spec.HostPortName, hpc.Spec.ReleaseAfterUnused.Duration)
+
+		hp := &hostportv1alpha1.HostPort{}
+		err := r.Get(ctx, types.NamespacedName{Name: hpc.Spec.HostPortName}, hp)
+		if err != nil {
+			if apierrors.IsNotFound(err) == false {
+				return ctrl.Result{}, err
+			}
+			hp = nil
+		}
+
+		hpc.Status.Phase = hostportv1alpha1.HostPortClaimPhaseReleased
 		err = r.Status().Update(ctx, hpc)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
+
+		// only delete the HostPort if it is bound to this claim
+		if hp != nil && hp.Spec.ClaimRef != nil && hp.Spec.ClaimRef.UID == hpc.UID {
+			err = r.Delete(ctx, hp)
+			if err != nil {
+				return ctrl.Result{}, client.IgnoreNotFound(err)
+			}
+		}
 		return ctrl.Result{}, nil
 	}
 
+	r.Recorder.Eventf(hpc, corev1.EventTypeNormal, "Deleting", "Deleting claim, it has been unused for %s", hpc.Spec.ReleaseAfterUnused.Duration)
+	err := r.Delete(ctx, hpc)
+	if err != nil {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
 	return ctrl.Result{}, nil
 }
 
-func (r *HostPortClaimReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&hostportv1alpha1.HostPortClaim{}).
-		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, object client.Object) []reconcile.Request {
-			pod := object.(*corev1.Pod)
-			var req []reconcile.Request
+// provisionClass returns the first class of the claim with a port the claim's HostPort could be allocated,
+// taking the HostPorts already waiting in the class into account.
+// When every class is exhausted the most preferred class that exists is returned so the HostPort waits in its queue,
+// the HostPort stays in that class when a fallback class frees ports later.
+func (r *HostPortClaimReconciler) provisionClass(ctx context.Context, hpc *hostportv1alpha1.HostPortClaim) (string, error) {
+	var waitClassName string
 
-			for annotation, value := range pod.Annotations {
-				if strings.HasPrefix(annotation, hostportv1alpha1.HostPortPodAnnotationClaimPrefix+"/") {
-					req = append(req, reconcile.Request{
-						NamespacedName: types.NamespacedName{
-							Namespace: pod.Namespace,
-							Name:      value,
-						},
-					})
-				}
+	for _, hostPortClassName := range hpc.HostPortClassNames() {
+		hpcl := &hostportv1alpha1.HostPortClass{}
+		err := r.Get(ctx, types.NamespacedName{Name: hostPortClassName}, hpcl)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
 			}
+			return "", err
+		}
 
-			return req
-		})).
+		if hpcl.DeletionTimestamp.IsZero() == false {
+			continue
+		}
+
+		if len(waitClassName) == 0 {
+			waitClassName = hpcl.Name
+		}
+
+		// the HostPort that would be provisioned, it is queued after the HostPorts already waiting
+		hp := &hostportv1alpha1.HostPort{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              fmt.Sprintf("hpc-%s", hpc.UID),
+				CreationTimestamp: metav1.Now(),
+			},
+			Spec: hostportv1alpha1.HostPortSpec{
+				ClaimRef: &corev1.ObjectReference{
+					Namespace: hpc.Namespace,
+					Name:      hpc.Name,
+					UID:       hpc.UID,
+				},
+				HostPortClassName: hpcl.Name,
+				Priority:          hpc.Spec.Priority,
+			},
+		}
+
+		ports, err := allocatablePorts(ctx, r.Client, r.Log, hp, hpcl)
+		if err != nil {
+			return "", err
+		}
+
+		if len(ports) > 0 {
+			return hpcl.Name, nil
+		}
+	}
+
+	// the HostPort reports the missing class
+	if len(waitClassName) == 0 {
+		return hpc.Spec.HostPortClassName, nil
+	}
+
+	return waitClassName, nil
+}
+
+func (r *HostPortClaimReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &corev1.Pod{}, podClaimIndex, func(rawObj client.Object) []string {
+		pod := rawObj.(*corev1.Pod)
+		return podClaims(pod)
+	}); err != nil {
+		return err
+	}
+
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&hostportv1alpha1.HostPortClaim{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(podClaimRequests)).
 		Watches(&hostportv1alpha1.HostPort{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, object client.Object) []reconcile.Request {
 			hp := object.(*hostportv1alpha1.HostPort)
 			var req []reconcile.Request
@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/rmb938/hostport-allocator/api/meta"
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+	intmetav1 "github.com/rmb938/hostport-allocator/apis/meta/v1"
+)
+
+func TestProvisionClass(t *testing.T) {
+	hostPortClass := func(name string, port int) *hostportv1alpha1.HostPortClass {
+		return &hostportv1alpha1.HostPortClass{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: hostportv1alpha1.HostPortClassSpec{
+				Pools: []hostportv1alpha1.HostPortClassSpecPool{{Start: port, End: port}},
+			},
+		}
+	}
+
+	// takes the only port of the class
+	allocated := func(name string, hostPortClassName string, port int) *hostportv1alpha1.HostPort {
+		return &hostportv1alpha1.HostPort{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       hostportv1alpha1.HostPortSpec{HostPortClassName: hostPortClassName},
+			Status:     hostportv1alpha1.HostPortStatus{Phase: hostportv1alpha1.HostPortPhaseAllocated, Port: port},
+		}
+	}
+
+	deleting := hostPortClass("deleting", 9300)
+	deleting.Finalizers = []string{hostportv1alpha1.HostPortFinalizer}
+	deleting.DeletionTimestamp = &metav1.Time{Time: metav1.Now().Time}
+
+	tests := []struct {
+		name     string
+		objects  []client.Object
+		classes  []string
+		expected string
+	}{
+		{
+			name:     "most preferred class with free ports",
+			objects:  []client.Object{hostPortClass("fast", 9000), hostPortClass("overflow", 9100)},
+			classes:  []string{"fast", "overflow"},
+			expected: "fast",
+		},
+		{
+			name: "fallback when the preferred class is exhausted",
+			objects: []client.Object{hostPortClass("fast", 9000), hostPortClass("overflow", 9100),
+				allocated("taken", "fast", 9000)},
+			classes:  []string{"fast", "overflow"},
+			expected: "overflow",
+		},
+		{
+			name: "fallback when the free port is promised to a waiting HostPort",
+			objects: []client.Object{hostPortClass("fast", 9000), hostPortClass("overflow", 9100),
+				&hostportv1alpha1.HostPort{
+					ObjectMeta: metav1.ObjectMeta{Name: "waiting"},
+					Spec:       hostportv1alpha1.HostPortSpec{HostPortClassName: "fast"},
+					Status:     hostportv1alpha1.HostPortStatus{Phase: hostportv1alpha1.HostPortPhasePending},
+				}},
+			classes:  []string{"fast", "overflow"},
+			expected: "overflow",
+		},
+		{
+			name:     "skip missing and deleting classes",
+			objects:  []client.Object{deleting, hostPortClass("overflow", 9100)},
+			classes:  []string{"missing", "deleting", "overflow"},
+			expected: "overflow",
+		},
+		{
+			name: "wait in the most preferred existing class when all are exhausted",
+			objects: []client.Object{hostPortClass("fast", 9000), hostPortClass("overflow", 9100),
+				allocated("taken-fast", "fast", 9000), allocated("taken-overflow", "overflow", 9100)},
+			classes:  []string{"missing", "fast", "overflow"},
+			expected: "fast",
+		},
+		{
+			name:     "no class exists",
+			classes:  []string{"missing", "other"},
+			expected: "missing",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := append([]client.Object{&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}}, test.objects...)
+
+			c := newFakeClient(objects...)
+
+			hpc := &hostportv1alpha1.HostPortClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim", UID: "uid"},
+				Spec: hostportv1alpha1.HostPortClaimSpec{
+					HostPortClassName:          test.classes[0],
+					FallbackHostPortClassNames: test.classes[1:],
+				},
+			}
+
+			r := &HostPortClaimReconciler{Client: c}
+			hostPortClassName, err := r.provisionClass(context.Background(), hpc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if hostPortClassName != test.expected {
+				t.Errorf("expected class %s but got %s", test.expected, hostPortClassName)
+			}
+		})
+	}
+}
+
+func TestHostPortClaimReconcileUsers(t *testing.T) {
+	hpc := &hostportv1alpha1.HostPortClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim", UID: "uid"},
+		Spec:       hostportv1alpha1.HostPortClaimSpec{HostPortClassName: "class"},
+	}
+
+	tests := []struct {
+		name      string
+		pods      int
+		userCount int
+		users     int
+	}{
+		{name: "no pods", pods: 0, userCount: 0, users: 0},
+		{name: "under the cap", pods: 3, userCount: 3, users: 3},
+		{name: "over the cap", pods: maxClaimUsers + 5, userCount: maxClaimUsers + 5, users: maxClaimUsers},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := []client.Object{hpc.DeepCopy()}
+			for i := 0; i < test.pods; i++ {
+				objects = append(objects, &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Namespace:   "default",
+						Name:        fmt.Sprintf("pod-%03d", i),
+						Annotations: map[string]string{hostportv1alpha1.HostPortPodAnnotationClaimPrefix + "/game": hpc.Name},
+					},
+				})
+			}
+
+			c := newFakeClient(objects...)
+			r := &HostPortClaimReconciler{Client: c, Recorder: record.NewFakeRecorder(10)}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(hpc)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			updated := &hostportv1alpha1.HostPortClaim{}
+			err = c.Get(context.Background(), client.ObjectKeyFromObject(hpc), updated)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if updated.Status.UserCount != test.userCount {
+				t.Errorf("expected user count %d but got %d", test.userCount, updated.Status.UserCount)
+			}
+
+			if len(updated.Status.Users) != test.users {
+				t.Fatalf("expected %d users but got %d", test.users, len(updated.Status.Users))
+			}
+
+			// the first pods by name are listed
+			if test.users > 0 && updated.Status.Users[0].Name != "pod-000" {
+				t.Errorf("expected the first user to be pod-000 but got %s", updated.Status.Users[0].Name)
+			}
+		})
+	}
+}
+
+func TestHostPortClaimReconcileLost(t *testing.T) {
+	claim := func(phase hostportv1alpha1.HostPortClaimStatusPhase, policy hostportv1alpha1.HostPortClaimLostRecoveryPolicy) *hostportv1alpha1.HostPortClaim {
+		return &hostportv1alpha1.HostPortClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim", UID: "uid"},
+			Spec: hostportv1alpha1.HostPortClaimSpec{
+				HostPortClassName:  "class",
+				HostPortName:       "hpc-uid",
+				LostRecoveryPolicy: policy,
+			},
+			Status: hostportv1alpha1.HostPortClaimStatus{
+				Phase:             phase,
+				HostPortClassName: "fallback",
+				Port:              9005,
+			},
+		}
+	}
+
+	hostPort := &hostportv1alpha1.HostPort{
+		ObjectMeta: metav1.ObjectMeta{Name: "hpc-uid"},
+		Spec:       hostportv1alpha1.HostPortSpec{HostPortClassName: "fallback"},
+		Status:     hostportv1alpha1.HostPortStatus{Phase: hostportv1alpha1.HostPortPhaseAllocated, Port: 9005},
+	}
+
+	tests := []struct {
+		name        string
+		hpc         *hostportv1alpha1.HostPortClaim
+		hostPort    bool
+		phase       hostportv1alpha1.HostPortClaimStatusPhase
+		lost        intmetav1.ConditionStatus
+		provisioned bool
+	}{
+		{
+			name:  "bound HostPort disappears",
+			hpc:   claim(hostportv1alpha1.HostPortClaimPhaseBound, hostportv1alpha1.HostPortClaimLostRecoveryPolicyNone),
+			phase: hostportv1alpha1.HostPortClaimPhaseLost,
+			lost:  intmetav1.ConditionTrue,
+		},
+		{
+			name:     "lost HostPort comes back",
+			hpc:      claim(hostportv1alpha1.HostPortClaimPhaseLost, hostportv1alpha1.HostPortClaimLostRecoveryPolicyNone),
+			hostPort: true,
+			phase:    hostportv1alpha1.HostPortClaimPhasePending,
+			lost:     intmetav1.ConditionFalse,
+		},
+		{
+			name:  "lost without recovery",
+			hpc:   claim(hostportv1alpha1.HostPortClaimPhaseLost, hostportv1alpha1.HostPortClaimLostRecoveryPolicyNone),
+			phase: hostportv1alpha1.HostPortClaimPhaseLost,
+		},
+		{
+			name:        "lost with reprovisioning",
+			hpc:         claim(hostportv1alpha1.HostPortClaimPhaseLost, hostportv1alpha1.HostPortClaimLostRecoveryPolicyReprovision),
+			phase:       hostportv1alpha1.HostPortClaimPhaseLost,
+			provisioned: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			objects := []client.Object{test.hpc}
+			if test.hostPort {
+				objects = append(objects, hostPort.DeepCopy())
+			}
+
+			c := newFakeClient(objects...)
+			r := &HostPortClaimReconciler{Client: c, Recorder: record.NewFakeRecorder(10)}
+
+			_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: client.ObjectKeyFromObject(test.hpc)})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			hpc := &hostportv1alpha1.HostPortClaim{}
+			err = c.Get(context.Background(), client.ObjectKeyFromObject(test.hpc), hpc)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if hpc.Status.Phase != test.phase {
+				t.Errorf("expected phase %s but got %s", test.phase, hpc.Status.Phase)
+			}
+
+			if len(test.lost) > 0 {
+				condition := meta.FindStatusCondition(hpc.Status.Conditions, hostportv1alpha1.HostPortClaimConditionLost)
+				if condition == nil || condition.Status != test.lost {
+					t.Errorf("expected lost condition %s but got %v", test.lost, condition)
+				}
+			}
+
+			hp := &hostportv1alpha1.HostPort{}
+			err = c.Get(context.Background(), client.ObjectKey{Name: test.hpc.Spec.HostPortName}, hp)
+			if test.hostPort == false {
+				if test.provisioned != (err == nil) {
+					t.Fatalf("expected provisioned to be %v but got %v", test.provisioned, err)
+				}
+
+				// the replacement keeps the class and asks for the port of the lost HostPort
+				if test.provisioned && (hp.Spec.HostPortClassName != "fallback" || hp.Spec.PreferredPort != 9005 || hp.Spec.ClaimRef.UID != test.hpc.UID) {
+					t.Errorf("unexpected replacement HostPort spec %+v", hp.Spec)
+				}
+			}
+		})
+	}
+}
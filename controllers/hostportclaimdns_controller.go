@@ -0,0 +1,268 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+// the ExternalDNS DNSEndpoint CRD, used unstructured so ExternalDNS isn't a dependency
+var dnsEndpointGVK = schema.GroupVersionKind{Group: "externaldns.k8s.io", Version: "v1alpha1", Kind: "DNSEndpoint"}
+
+// HostPortClaimDNSReconciler maintains an ExternalDNS DNSEndpoint with SRV and A records
+// for the ready endpoints of claims annotated with a DNS zone
+type HostPortClaimDNSReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportclaims,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups=externaldns.k8s.io,resources=dnsendpoints,verbs=get;list;watch;create;update;patch;delete
+
+func (r *HostPortClaimDNSReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = r.Log.WithValues("hostportclaim", req.NamespacedName)
+
+	hpc := &hostportv1alpha1.HostPortClaim{}
+	err := r.Get(ctx, req.NamespacedName, hpc)
+	if err != nil {
+		err = client.IgnoreNotFound(err)
+		return ctrl.Result{}, err
+	}
+
+	dnsEndpoint := &unstructured.Unstructured{}
+	dnsEndpoint.SetGroupVersionKind(dnsEndpointGVK)
+
+	zone := strings.Trim(hpc.Annotations[hostportv1alpha1.HostPortClaimAnnotationDNSZone], ".")
+	if hpc.DeletionTimestamp.IsZero() == false || len(zone) == 0 {
+		err = r.Get(ctx, req.NamespacedName, dnsEndpoint)
+		if err != nil {
+			err = client.IgnoreNotFound(err)
+			return ctrl.Result{}, err
+		}
+
+		if metav1.IsControlledBy(dnsEndpoint, hpc) {
+			err = r.Delete(ctx, dnsEndpoint)
+			if err != nil && apierrors.IsNotFound(err) == false {
+				return ctrl.Result{}, err
+			}
+		}
+
+		return ctrl.Result{}, nil
+	}
+
+	records, err := r.claimRecords(ctx, hpc, zone)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	dnsEndpoint.SetNamespace(hpc.Namespace)
+	dnsEndpoint.SetName(hpc.Name)
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, dnsEndpoint, func() error {
+		err := unstructured.SetNestedSlice(dnsEndpoint.Object, records, "spec", "endpoints")
+		if err != nil {
+			return err
+		}
+		return controllerutil.SetControllerReference(hpc, dnsEndpoint, r.Scheme)
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// claimRecords returns the DNSEndpoint endpoints for the claim.
+// Every node running a ready pod gets A and AAAA records <node>.<claim>.<zone> for its addresses, which are the target of the
+// _<service>._<protocol>.<claim>.<zone> SRV record, and <claim>.<zone> has A and AAAA records for all the nodes.
+func (r *HostPortClaimDNSReconciler) claimRecords(ctx context.Context, hpc *hostportv1alpha1.HostPortClaim, zone string) ([]interface{}, error) {
+	var ttl int64
+	if value, ok := hpc.Annotations[hostportv1alpha1.HostPortClaimAnnotationDNSTTL]; ok {
+		var err error
+		ttl, err = strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s annotation: %w", hostportv1alpha1.HostPortClaimAnnotationDNSTTL, err)
+		}
+	}
+
+	service := hpc.Name
+	if value, ok := hpc.Annotations[hostportv1alpha1.HostPortClaimAnnotationDNSSRVService]; ok {
+		service = value
+	}
+
+	protocol := "tcp"
+	if value, ok := hpc.Annotations[hostportv1alpha1.HostPortClaimAnnotationDNSSRVProtocol]; ok {
+		protocol = strings.ToLower(value)
+	}
+
+	claimName := hpc.Name + "." + zone
+
+	endpoints := append([]hostportv1alpha1.HostPortClaimEndpoint{}, hpc.Status.Endpoints...)
+	sort.Slice(endpoints, func(i, j int) bool {
+		return endpoints[i].Node < endpoints[j].Node
+	})
+
+	recordTypes := []string{"A", "AAAA"}
+	claimTargets := make(map[string][]string)
+	srvTargets := make([]string, 0)
+	records := make([]interface{}, 0)
+	seenNodes := make(map[string]struct{})
+	for _, endpoint := range endpoints {
+		if endpoint.Ready == false || len(endpoint.Node) == 0 {
+			continue
+		}
+
+		// pods of a claim on the same node share the host port
+		if _, ok := seenNodes[endpoint.Node]; ok {
+			continue
+		}
+		seenNodes[endpoint.Node] = struct{}{}
+
+		addresses, err := r.nodeAddresses(ctx, endpoint)
+		if err != nil {
+			return nil, err
+		}
+
+		nodeTargets := make(map[string][]string)
+		for _, address := range addresses {
+			recordType := addressRecordType(address)
+			if len(recordType) == 0 {
+				continue
+			}
+			nodeTargets[recordType] = append(nodeTargets[recordType], address)
+			claimTargets[recordType] = append(claimTargets[recordType], address)
+		}
+
+		if len(nodeTargets) == 0 {
+			continue
+		}
+
+		nodeName := endpoint.Node + "." + claimName
+		for _, recordType := range recordTypes {
+			if len(nodeTargets[recordType]) > 0 {
+				records = append(records, dnsRecord(nodeName, recordType, ttl, nodeTargets[recordType]))
+			}
+		}
+		srvTargets = append(srvTargets, fmt.Sprintf("0 50 %d %s", endpoint.Port, nodeName))
+	}
+
+	for _, recordType := range recordTypes {
+		if len(claimTargets[recordType]) > 0 {
+			sort.Strings(claimTargets[recordType])
+			records = append(records, dnsRecord(claimName, recordType, ttl, claimTargets[recordType]))
+		}
+	}
+
+	if len(srvTargets) > 0 {
+		sort.Strings(srvTargets)
+		records = append(records,
+			dnsRecord(fmt.Sprintf("_%s._%s.%s", service, protocol, claimName), "SRV", ttl, srvTargets),
+		)
+	}
+
+	return records, nil
+}
+
+// nodeAddresses returns the external IPs of the endpoint's node, falling back to the node IP the pod reported
+func (r *HostPortClaimDNSReconciler) nodeAddresses(ctx context.Context, endpoint hostportv1alpha1.HostPortClaimEndpoint) ([]string, error) {
+	node := &corev1.Node{}
+	err := r.Get(ctx, types.NamespacedName{Name: endpoint.Node}, node)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return []string{endpoint.NodeIP}, nil
+		}
+		return nil, err
+	}
+
+	var addresses []string
+	for _, address := range node.Status.Addresses {
+		if address.Type == corev1.NodeExternalIP {
+			addresses = append(addresses, address.Address)
+		}
+	}
+
+	if len(addresses) == 0 {
+		return []string{endpoint.NodeIP}, nil
+	}
+
+	return addresses, nil
+}
+
+// addressRecordType returns the DNS record type for the address family of the IP
+func addressRecordType(address string) string {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return ""
+	}
+
+	if ip.To4() != nil {
+		return "A"
+	}
+
+	return "AAAA"
+}
+
+// dnsRecord returns a DNSEndpoint endpoint
+func dnsRecord(dnsName, recordType string, ttl int64, targets []string) map[string]interface{} {
+	record := map[string]interface{}{
+		"dnsName":    dnsName,
+		"recordType": recordType,
+	}
+
+	recordTargets := make([]interface{}, 0, len(targets))
+	for _, target := range targets {
+		recordTargets = append(recordTargets, target)
+	}
+	record["targets"] = recordTargets
+
+	if ttl > 0 {
+		record["recordTTL"] = ttl
+	}
+
+	return record
+}
+
+func (r *HostPortClaimDNSReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	dnsEndpoint := &unstructured.Unstructured{}
+	dnsEndpoint.SetGroupVersionKind(dnsEndpointGVK)
+
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("hostportclaimdns").
+		For(&hostportv1alpha1.HostPortClaim{}).
+		Owns(dnsEndpoint).
+		Complete(r)
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+func TestHostPortClaimDNSReconcile(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = hostportv1alpha1.AddToScheme(scheme)
+
+	hpc := &hostportv1alpha1.HostPortClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "game",
+			UID:       "uid",
+			Annotations: map[string]string{
+				hostportv1alpha1.HostPortClaimAnnotationDNSZone:        "example.com.",
+				hostportv1alpha1.HostPortClaimAnnotationDNSTTL:         "60",
+				hostportv1alpha1.HostPortClaimAnnotationDNSSRVProtocol: "UDP",
+			},
+		},
+		Status: hostportv1alpha1.HostPortClaimStatus{
+			Endpoints: []hostportv1alpha1.HostPortClaimEndpoint{
+				{Pod: "game-0", Node: "node-a", NodeIP: "10.0.0.1", Port: 9000, Ready: true},
+				{Pod: "game-1", Node: "node-b", NodeIP: "10.0.0.2", Port: 9000, Ready: true},
+				{Pod: "game-2", Node: "node-c", NodeIP: "10.0.0.3", Port: 9000, Ready: false},
+			},
+		},
+	}
+
+	// a dual stack node, node-b has no external IPs so its node IP is used
+	nodeA := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-a"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{
+				{Type: corev1.NodeInternalIP, Address: "10.0.0.1"},
+				{Type: corev1.NodeExternalIP, Address: "203.0.113.1"},
+				{Type: corev1.NodeExternalIP, Address: "2001:db8::1"},
+			},
+		},
+	}
+	nodeB := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "node-b"},
+		Status: corev1.NodeStatus{
+			Addresses: []corev1.NodeAddress{{Type: corev1.NodeInternalIP, Address: "10.0.0.2"}},
+		},
+	}
+
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(hpc, nodeA, nodeB).Build()
+
+	r := &HostPortClaimDNSReconciler{Client: c, Log: logr.Discard(), Scheme: scheme}
+	_, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "game"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dnsEndpoint := &unstructured.Unstructured{}
+	dnsEndpoint.SetGroupVersionKind(dnsEndpointGVK)
+	err = c.Get(context.Background(), types.NamespacedName{Namespace: "default", Name: "game"}, dnsEndpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if metav1.IsControlledBy(dnsEndpoint, hpc) == false {
+		t.Errorf("DNSEndpoint is not controlled by the claim")
+	}
+
+	endpoints, _, err := unstructured.NestedSlice(dnsEndpoint.Object, "spec", "endpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := func(dnsName, recordType string, targets ...interface{}) interface{} {
+		return map[string]interface{}{
+			"dnsName":    dnsName,
+			"recordType": recordType,
+			"recordTTL":  int64(60),
+			"targets":    targets,
+		}
+	}
+
+	expected := []interface{}{
+		record("node-a.game.example.com", "A", "203.0.113.1"),
+		record("node-a.game.example.com", "AAAA", "2001:db8::1"),
+		record("node-b.game.example.com", "A", "10.0.0.2"),
+		record("game.example.com", "A", "10.0.0.2", "203.0.113.1"),
+		record("game.example.com", "AAAA", "2001:db8::1"),
+		record("_game._udp.game.example.com", "SRV", "0 50 9000 node-a.game.example.com", "0 50 9000 node-b.game.example.com"),
+	}
+
+	if reflect.DeepEqual(endpoints, expected) == false {
+		t.Errorf("unexpected endpoints %v", endpoints)
+	}
+}
@@ -0,0 +1,283 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+// the name of the port in published Services and EndpointSlices
+const hostPortServicePortName = "hostport"
+
+// HostPortClaimServiceReconciler publishes the node IP and allocated port of every pod using a claim
+// as a selector-less Service with EndpointSlices for claims opted in with the publish service annotation
+type HostPortClaimServiceReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportclaims,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=services,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch;create;update;patch;delete
+
+func (r *HostPortClaimServiceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	log := r.Log.WithValues("hostportclaim", req.NamespacedName)
+
+	hpc := &hostportv1alpha1.HostPortClaim{}
+	err := r.Get(ctx, req.NamespacedName, hpc)
+	if err != nil {
+		err = client.IgnoreNotFound(err)
+		return ctrl.Result{}, err
+	}
+
+	// only bound claims have a port to publish
+	if hpc.DeletionTimestamp.IsZero() == false || hpc.Annotations[hostportv1alpha1.HostPortClaimAnnotationPublishService] != "true" ||
+		hpc.Status.Phase != hostportv1alpha1.HostPortClaimPhaseBound || hpc.Status.Port == 0 {
+		return ctrl.Result{}, r.deletePublished(ctx, hpc)
+	}
+
+	svc := &corev1.Service{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: hpc.Namespace, Name: hpc.Name}, svc)
+	if err != nil {
+		if apierrors.IsNotFound(err) == false {
+			return ctrl.Result{}, err
+		}
+	} else if metav1.IsControlledBy(svc, hpc) == false {
+		log.Info("not publishing claim, a service with the same name already exists")
+		return ctrl.Result{}, nil
+	}
+
+	podList := &corev1.PodList{}
+	err = r.List(ctx, podList, client.InNamespace(hpc.Namespace), client.MatchingFields{podClaimIndex: hpc.Name})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	sort.Slice(podList.Items, func(i, j int) bool {
+		return podList.Items[i].Name < podList.Items[j].Name
+	})
+
+	protocol := corev1.ProtocolTCP
+	endpoints := make(map[discoveryv1.AddressType][]discoveryv1.Endpoint)
+	for _, pod := range podList.Items {
+		port := podClaimPort(&pod, hpc.Name)
+		if port == nil || len(pod.Status.HostIP) == 0 {
+			continue
+		}
+		protocol = port.protocol
+
+		addressType := discoveryv1.AddressTypeIPv4
+		if net.ParseIP(pod.Status.HostIP).To4() == nil {
+			addressType = discoveryv1.AddressTypeIPv6
+		}
+
+		ready := podReady(&pod)
+		serving := ready
+		terminating := pod.DeletionTimestamp.IsZero() == false
+		nodeName := pod.Spec.NodeName
+		endpoints[addressType] = append(endpoints[addressType], discoveryv1.Endpoint{
+			Addresses: []string{pod.Status.HostIP},
+			Conditions: discoveryv1.EndpointConditions{
+				Ready:       &ready,
+				Serving:     &serving,
+				Terminating: &terminating,
+			},
+			NodeName: &nodeName,
+			TargetRef: &corev1.ObjectReference{
+				Kind:      "Pod",
+				Namespace: pod.Namespace,
+				Name:      pod.Name,
+				UID:       pod.UID,
+			},
+		})
+	}
+
+	svc = &corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: hpc.Namespace, Name: hpc.Name}}
+	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, svc, func() error {
+		// headless so DNS resolves to the node IPs
+		if len(svc.Spec.ClusterIP) == 0 {
+			svc.Spec.ClusterIP = corev1.ClusterIPNone
+		}
+		svc.Spec.Ports = []corev1.ServicePort{
+			{
+				Name:       hostPortServicePortName,
+				Protocol:   protocol,
+				Port:       int32(hpc.Status.Port),
+				TargetPort: intstr.FromInt(hpc.Status.Port),
+			},
+		}
+		return controllerutil.SetControllerReference(hpc, svc, r.Scheme)
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	for _, addressType := range []discoveryv1.AddressType{discoveryv1.AddressTypeIPv4, discoveryv1.AddressTypeIPv6} {
+		eps := &discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{
+			Namespace: hpc.Namespace,
+			Name:      hpc.Name + "-" + strings.ToLower(string(addressType)),
+		}}
+
+		if len(endpoints[addressType]) == 0 {
+			err = r.Delete(ctx, eps)
+			if err != nil && apierrors.IsNotFound(err) == false {
+				return ctrl.Result{}, err
+			}
+			continue
+		}
+
+		_, err = controllerutil.CreateOrUpdate(ctx, r.Client, eps, func() error {
+			if eps.Labels == nil {
+				eps.Labels = make(map[string]string)
+			}
+			eps.Labels[discoveryv1.LabelServiceName] = svc.Name
+			eps.Labels[discoveryv1.LabelManagedBy] = hostportv1alpha1.GroupVersion.Group
+
+			portName := hostPortServicePortName
+			port := int32(hpc.Status.Port)
+			eps.AddressType = addressType
+			eps.Endpoints = endpoints[addressType]
+			eps.Ports = []discoveryv1.EndpointPort{
+				{
+					Name:     &portName,
+					Protocol: &protocol,
+					Port:     &port,
+				},
+			}
+			return controllerutil.SetControllerReference(hpc, eps, r.Scheme)
+		})
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// deletePublished deletes the Service and EndpointSlices published for the claim
+func (r *HostPortClaimServiceReconciler) deletePublished(ctx context.Context, hpc *hostportv1alpha1.HostPortClaim) error {
+	epsList := &discoveryv1.EndpointSliceList{}
+	err := r.List(ctx, epsList, client.InNamespace(hpc.Namespace), client.MatchingLabels{
+		discoveryv1.LabelServiceName: hpc.Name,
+		discoveryv1.LabelManagedBy:   hostportv1alpha1.GroupVersion.Group,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, eps := range epsList.Items {
+		if metav1.IsControlledBy(&eps, hpc) == false {
+			continue
+		}
+
+		err = r.Delete(ctx, &eps)
+		if err != nil && apierrors.IsNotFound(err) == false {
+			return err
+		}
+	}
+
+	svc := &corev1.Service{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: hpc.Namespace, Name: hpc.Name}, svc)
+	if err != nil {
+		return client.IgnoreNotFound(err)
+	}
+
+	if metav1.IsControlledBy(svc, hpc) == false {
+		return nil
+	}
+
+	return client.IgnoreNotFound(r.Delete(ctx, svc))
+}
+
+// claimPort is a port of a pod using a claim
+type claimPort struct {
+	name     string
+	port     int
+	protocol corev1.Protocol
+}
+
+// podClaimPort returns the port of the pod using the claim or nil if the pod has not been given the port
+func podClaimPort(pod *corev1.Pod, claimName string) *claimPort {
+	for annotation, value := range pod.Annotations {
+		if strings.HasPrefix(annotation, hostportv1alpha1.HostPortPodAnnotationClaimPrefix+"/") == false || value != claimName {
+			continue
+		}
+
+		portName := strings.Split(annotation, "/")[1]
+		port, err := strconv.Atoi(pod.Annotations[hostportv1alpha1.HostPortPodAnnotationPortPrefix+"/"+portName])
+		if err != nil {
+			continue
+		}
+
+		protocol := corev1.ProtocolTCP
+		for _, containers := range [][]corev1.Container{pod.Spec.Containers, pod.Spec.InitContainers} {
+			for _, container := range containers {
+				for _, containerPort := range container.Ports {
+					if containerPort.Name == portName && len(containerPort.Protocol) > 0 {
+						protocol = containerPort.Protocol
+					}
+				}
+			}
+		}
+
+		return &claimPort{name: portName, port: port, protocol: protocol}
+	}
+
+	return nil
+}
+
+// podReady returns true if the pod has the Ready condition
+func podReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+func (r *HostPortClaimServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("hostportclaimservice").
+		For(&hostportv1alpha1.HostPortClaim{}).
+		Owns(&corev1.Service{}).
+		Owns(&discoveryv1.EndpointSlice{}).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(podClaimRequests)).
+		Complete(r)
+}
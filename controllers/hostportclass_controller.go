@@ -20,10 +20,16 @@ import (
 	"context"
 
 	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
 )
@@ -31,12 +37,15 @@ import (
 // HostPortClassReconciler reconciles a HostPortClass object
 type HostPortClassReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
 }
 
 // +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportclasses,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportclasses/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostports,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *HostPortClassReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
 	_ = r.Log.WithValues("hostportclass", req.NamespacedName)
@@ -65,11 +74,76 @@ func (r *HostPortClassReconciler) Reconcile(ctx context.Context, req ctrl.Reques
 		return ctrl.Result{}, nil
 	}
 
+	hostPortList := &hostportv1alpha1.HostPortList{}
+	err = r.List(ctx, hostPortList, client.MatchingFields{"spec.hostPortClassName": hpcl.Name})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	pools := make([]hostportv1alpha1.HostPortClassStatusPool, 0, len(hpcl.Spec.Pools))
+	for _, pool := range hpcl.Spec.Pools {
+		statusPool := hostportv1alpha1.HostPortClassStatusPool{
+			Name:      pool.Name,
+			Start:     pool.Start,
+			End:       pool.End,
+			Dedicated: pool.NamespaceSelector != nil,
+			Capacity:  pool.End - pool.Start + 1,
+		}
+
+		for _, hp := range hostPortList.Items {
+			if hp.Status.Port >= pool.Start && hp.Status.Port <= pool.End {
+				statusPool.Allocated++
+			}
+		}
+
+		pools = append(pools, statusPool)
+	}
+
+	if equality.Semantic.DeepEqual(hpcl.Status.Pools, pools) == false {
+		// only report exhaustion changes once the class has been reconciled before
+		if len(hpcl.Status.Pools) > 0 {
+			wasExhausted := classFreePorts(hpcl.Status.Pools) == 0
+			exhausted := classFreePorts(pools) == 0
+			if exhausted && wasExhausted == false {
+				r.Recorder.Event(hpcl, corev1.EventTypeWarning, "Exhausted", "All ports in the class are allocated")
+			}
+			if exhausted == false && wasExhausted {
+				r.Recorder.Eventf(hpcl, corev1.EventTypeNormal, "PortsAvailable", "%d ports in the class are free", classFreePorts(pools))
+			}
+		}
+
+		hpcl.Status.Pools = pools
+		err = r.Status().Update(ctx, hpcl)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
 	return ctrl.Result{}, nil
 }
 
+// classFreePorts returns the number of free ports in the pools
+func classFreePorts(pools []hostportv1alpha1.HostPortClassStatusPool) int {
+	free := 0
+	for _, pool := range pools {
+		free += pool.Capacity - pool.Allocated
+	}
+	return free
+}
+
 func (r *HostPortClassReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&hostportv1alpha1.HostPortClass{}).
+		Watches(&hostportv1alpha1.HostPort{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, object client.Object) []reconcile.Request {
+			hp := object.(*hostportv1alpha1.HostPort)
+
+			return []reconcile.Request{
+				{
+					NamespacedName: types.NamespacedName{
+						Name: hp.Spec.HostPortClassName,
+					},
+				},
+			}
+		})).
 		Complete(r)
 }
@@ -0,0 +1,134 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+// HostPortOwnerReconciler maintains the ownership labels between dynamically provisioned
+// HostPorts and their HostPortClaims and deletes HostPorts whose owning claim is gone
+type HostPortOwnerReconciler struct {
+	client.Client
+	Log    logr.Logger
+	Scheme *runtime.Scheme
+}
+
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostports,verbs=get;list;watch;update;patch;delete
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportclaims,verbs=get;list;watch
+
+func (r *HostPortOwnerReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	_ = r.Log.WithValues("hostport", req.NamespacedName)
+
+	hp := &hostportv1alpha1.HostPort{}
+	err := r.Get(ctx, req.NamespacedName, hp)
+	if err != nil {
+		err = client.IgnoreNotFound(err)
+		return ctrl.Result{}, err
+	}
+
+	if hp.DeletionTimestamp.IsZero() == false || hp.Spec.ClaimRef == nil {
+		return ctrl.Result{}, nil
+	}
+
+	// label dynamically provisioned HostPorts created before ownership labels existed
+	if _, ok := hp.Labels[hostportv1alpha1.HostPortLabelClaimUID]; !ok {
+		if hp.Name != fmt.Sprintf("hpc-%s", hp.Spec.ClaimRef.UID) {
+			return ctrl.Result{}, nil
+		}
+
+		patch := client.MergeFrom(hp.DeepCopy())
+		if hp.Labels == nil {
+			hp.Labels = make(map[string]string)
+		}
+		hp.Labels[hostportv1alpha1.HostPortLabelClaimNamespace] = hp.Spec.ClaimRef.Namespace
+		hp.Labels[hostportv1alpha1.HostPortLabelClaimUID] = string(hp.Spec.ClaimRef.UID)
+		err = r.Patch(ctx, hp, patch)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	hpc := &hostportv1alpha1.HostPortClaim{}
+	err = r.Get(ctx, types.NamespacedName{Namespace: hp.Spec.ClaimRef.Namespace, Name: hp.Spec.ClaimRef.Name}, hpc)
+	if err != nil {
+		if apierrors.IsNotFound(err) == false {
+			return ctrl.Result{}, err
+		}
+		hpc = nil
+	}
+
+	// the owning claim is gone or was replaced so delete the HostPort
+	if hpc == nil || string(hpc.UID) != hp.Labels[hostportv1alpha1.HostPortLabelClaimUID] {
+		err = r.Delete(ctx, hp)
+		if err != nil {
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// claimOwnerLabels returns the labels marking a HostPort as owned by the claim
+func claimOwnerLabels(hpc *hostportv1alpha1.HostPortClaim) map[string]string {
+	return map[string]string{
+		hostportv1alpha1.HostPortLabelClaimNamespace: hpc.Namespace,
+		hostportv1alpha1.HostPortLabelClaimUID:       string(hpc.UID),
+	}
+}
+
+func (r *HostPortOwnerReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("hostportowner").
+		For(&hostportv1alpha1.HostPort{}).
+		Watches(&hostportv1alpha1.HostPortClaim{}, handler.EnqueueRequestsFromMapFunc(func(ctx context.Context, object client.Object) []reconcile.Request {
+			hpc := object.(*hostportv1alpha1.HostPortClaim)
+			var req []reconcile.Request
+
+			// a replaced claim's HostPorts are enqueued by the delete event of the claim they were labeled with
+			hostPortList := &hostportv1alpha1.HostPortList{}
+			err := r.List(ctx, hostPortList, client.MatchingLabels(claimOwnerLabels(hpc)))
+			if err != nil {
+				r.Log.Error(err, "error listing owned hostports", "hostportclaim", types.NamespacedName{Namespace: hpc.Namespace, Name: hpc.Name})
+				return req
+			}
+
+			for _, hp := range hostPortList.Items {
+				req = append(req, reconcile.Request{
+					NamespacedName: types.NamespacedName{
+						Name: hp.Name,
+					},
+				})
+			}
+
+			return req
+		})).
+		Complete(r)
+}
@@ -50,6 +50,15 @@ app.kubernetes.io/name: {{ include "hostport-allocator.name" . }}
 app.kubernetes.io/instance: {{ .Release.Name }}
 {{- end }}
 
+{{/*
+Agent selector labels
+These must not match the selector labels of the controller manager
+*/}}
+{{- define "hostport-allocator.agentSelectorLabels" -}}
+app.kubernetes.io/name: {{ include "hostport-allocator.name" . }}-agent
+app.kubernetes.io/instance: {{ .Release.Name }}
+{{- end }}
+
 {{/*
 Create the name of the service account to use
 */}}
@@ -61,6 +70,17 @@ Create the name of the service account to use
 {{- end }}
 {{- end }}
 
+{{/*
+Create the name of the service account to use for the agent
+*/}}
+{{- define "hostport-allocator.agentServiceAccountName" -}}
+{{- if .Values.agent.serviceAccount.create }}
+{{- default (printf "%s-agent" (include "hostport-allocator.fullname" .)) .Values.agent.serviceAccount.name }}
+{{- else }}
+{{- default "default" .Values.agent.serviceAccount.name }}
+{{- end }}
+{{- end }}
+
 {{/*
 Create the name of the certificate to use
 */}}
@@ -68,6 +88,17 @@ Create the name of the certificate to use
 {{- default (include "hostport-allocator.fullname" .) .Values.webhook.certificate.name }}
 {{- end }}
 
+{{/*
+Create the name of the service account to use for the agent
+*/}}
+{{- define "hostport-allocator.agentServiceAccountName" -}}
+{{- if .Values.agent.serviceAccount.create }}
+{{- default (printf "%s-agent" (include "hostport-allocator.fullname" .)) .Values.agent.serviceAccount.name }}
+{{- else }}
+{{- default "default" .Values.agent.serviceAccount.name }}
+{{- end }}
+{{- end }}
+
 {{/*
 Create the name of the certificate to use
 */}}
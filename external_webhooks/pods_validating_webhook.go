@@ -0,0 +1,110 @@
+package external_webhooks
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+type PodValidatingWebhook struct {
+	decoder admission.Decoder
+}
+
+func (w *PodValidatingWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	hookServer := mgr.GetWebhookServer()
+
+	hookServer.Register("/validate-v1-pod", &webhook.Admission{Handler: &PodValidatingWebhook{decoder: admission.NewDecoder(mgr.GetScheme())}})
+
+	return nil
+}
+
+var _ admission.Handler = &PodValidatingWebhook{}
+
+func (w *PodValidatingWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	pod := &corev1.Pod{}
+	err := w.decoder.Decode(req, pod)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	oldPod := &corev1.Pod{}
+	err = w.decoder.DecodeRaw(req.OldObject, oldPod)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	err = w.ValidateUpdate(ctx, oldPod, pod)
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+
+	return admission.Allowed("")
+}
+
+func (w *PodValidatingWebhook) ValidateUpdate(ctx context.Context, oldPod, r *corev1.Pod) error {
+	podlog.Info("validate update", "name", r.Name, "namespace", r.Namespace)
+
+	var allErrs field.ErrorList
+
+	// claim and port annotations can't be changed once the pod exists
+	// otherwise claim users and deletion blocking would be wrong
+	for annotation, value := range r.Annotations {
+		if isImmutablePodAnnotation(annotation) == false {
+			continue
+		}
+
+		if oldValue, ok := oldPod.Annotations[annotation]; !ok || oldValue != value {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("metadata").Child("annotations").Child(annotation),
+				"annotation is immutable"))
+		}
+	}
+
+	for annotation := range oldPod.Annotations {
+		if isImmutablePodAnnotation(annotation) == false {
+			continue
+		}
+
+		if _, ok := r.Annotations[annotation]; !ok {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("metadata").Child("annotations").Child(annotation),
+				"annotation is immutable"))
+		}
+	}
+
+	for containerIndex, container := range r.Spec.EphemeralContainers {
+		for portIndex, port := range container.Ports {
+			if port.HostPort > 0 {
+				allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("ephemeralContainers").Index(containerIndex).Child("ports").Index(portIndex).Child("hostPort"), port.HostPort,
+					"host ports cannot be set"))
+			}
+		}
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: "", Kind: "Pod"},
+		r.Name, allErrs)
+}
+
+// isImmutablePodAnnotation returns true for the claim and port annotations
+func isImmutablePodAnnotation(annotation string) bool {
+	return strings.HasPrefix(annotation, hostportv1alpha1.HostPortPodAnnotationClaimPrefix+"/") ||
+		strings.HasPrefix(annotation, hostportv1alpha1.HostPortPodAnnotationPortPrefix+"/")
+}
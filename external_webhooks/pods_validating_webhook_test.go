@@ -0,0 +1,113 @@
+package external_webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+func TestPodValidatingWebhookHandle(t *testing.T) {
+	claimAnnotation := hostportv1alpha1.HostPortPodAnnotationClaimPrefix + "/game"
+	portAnnotation := hostportv1alpha1.HostPortPodAnnotationPortPrefix + "/game"
+
+	pod := func(annotations map[string]string, ephemeralHostPort int32) *corev1.Pod {
+		p := &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod", Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}},
+		}
+		if ephemeralHostPort > 0 {
+			p.Spec.EphemeralContainers = []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+				Name: "debug", Ports: []corev1.ContainerPort{{ContainerPort: 8080, HostPort: ephemeralHostPort}},
+			}}}
+		}
+		return p
+	}
+
+	claimed := map[string]string{claimAnnotation: "claim", portAnnotation: "9000"}
+
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		oldPod    *corev1.Pod
+		pod       *corev1.Pod
+		allowed   bool
+	}{
+		{
+			name:      "unchanged",
+			operation: admissionv1.Update,
+			oldPod:    pod(claimed, 0),
+			pod:       pod(claimed, 0),
+			allowed:   true,
+		},
+		{
+			name:      "other annotations change",
+			operation: admissionv1.Update,
+			oldPod:    pod(claimed, 0),
+			pod:       pod(map[string]string{claimAnnotation: "claim", portAnnotation: "9000", "team": "games"}, 0),
+			allowed:   true,
+		},
+		{
+			name:      "claim annotation changed",
+			operation: admissionv1.Update,
+			oldPod:    pod(claimed, 0),
+			pod:       pod(map[string]string{claimAnnotation: "other", portAnnotation: "9000"}, 0),
+			allowed:   false,
+		},
+		{
+			name:      "port annotation added",
+			operation: admissionv1.Update,
+			oldPod:    pod(map[string]string{claimAnnotation: "claim"}, 0),
+			pod:       pod(claimed, 0),
+			allowed:   false,
+		},
+		{
+			name:      "port annotation removed",
+			operation: admissionv1.Update,
+			oldPod:    pod(claimed, 0),
+			pod:       pod(map[string]string{claimAnnotation: "claim"}, 0),
+			allowed:   false,
+		},
+		{
+			name:      "ephemeral container host port",
+			operation: admissionv1.Update,
+			oldPod:    pod(nil, 0),
+			pod:       pod(nil, 8080),
+			allowed:   false,
+		},
+		{
+			name:      "create is left to the mutating webhook",
+			operation: admissionv1.Create,
+			pod:       pod(claimed, 8080),
+			allowed:   true,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &PodValidatingWebhook{decoder: admission.NewDecoder(scheme)}
+
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: test.operation}}
+			req.Object.Raw, _ = json.Marshal(test.pod)
+			if test.oldPod != nil {
+				req.OldObject.Raw, _ = json.Marshal(test.oldPod)
+			}
+
+			resp := w.Handle(context.Background(), req)
+			if resp.Allowed != test.allowed {
+				t.Errorf("expected allowed to be %v but got %v", test.allowed, resp.Result)
+			}
+		})
+	}
+}
@@ -5,14 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
 
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -22,6 +25,7 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 
 	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+	"github.com/rmb938/hostport-allocator/metrics"
 )
 
 // log is for logging in this package.
@@ -52,7 +56,7 @@ func (w *PodWebhook) Handle(ctx context.Context, req admission.Request) admissio
 	}
 
 	// Default the object
-	err = w.Default(ctx, obj)
+	err = w.Default(admission.NewContextWithRequest(ctx, req), obj)
 	if err != nil {
 		return admission.Denied(err.Error())
 	}
@@ -71,7 +75,38 @@ func (w *PodWebhook) Default(ctx context.Context, obj runtime.Object) error {
 
 	podlog.Info("default", "name", r.Name, "namespace", r.Namespace)
 
-	var allErrs field.ErrorList
+	allErrs, claimErrs := w.defaultPod(ctx, r)
+
+	// pods are only counted when created, updates of existing pods would count them again
+	req, err := admission.RequestFromContext(ctx)
+	created := err != nil || req.Operation == admissionv1.Create
+
+	switch {
+	case created == false:
+	case len(allErrs) > 0:
+		metrics.PodWebhookDecisions.WithLabelValues(metrics.PodWebhookDecisionDeny, metrics.PodWebhookReasonInvalid).Inc()
+	case len(claimErrs) > 0:
+		metrics.PodWebhookDecisions.WithLabelValues(metrics.PodWebhookDecisionDeny, metrics.PodWebhookReasonClaimUnavailable).Inc()
+	case podHasClaims(r):
+		metrics.PodWebhookDecisions.WithLabelValues(metrics.PodWebhookDecisionAllow, metrics.PodWebhookReasonClaimed).Inc()
+	default:
+		metrics.PodWebhookDecisions.WithLabelValues(metrics.PodWebhookDecisionAllow, metrics.PodWebhookReasonNoClaims).Inc()
+	}
+
+	allErrs = append(allErrs, claimErrs...)
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: "", Kind: r.Kind},
+		r.Name, allErrs)
+}
+
+// defaultPod validates the pod and sets its host ports from the claims it references.
+// Errors caused by the current state of the referenced claims are returned separately in claimErrs.
+func (w *PodWebhook) defaultPod(ctx context.Context, r *corev1.Pod) (allErrs field.ErrorList, claimErrs field.ErrorList) {
 
 	definedClaims := make(map[string]string)
 	for annotation, value := range r.Annotations {
@@ -92,33 +127,129 @@ func (w *PodWebhook) Default(ctx context.Context, obj runtime.Object) error {
 		}
 	}
 
+	// add ports for claims that target a container which doesn't declare the port
+	for annotation, value := range r.Annotations {
+		if strings.HasPrefix(annotation, hostportv1alpha1.HostPortPodAnnotationContainerPrefix+"/") == false {
+			continue
+		}
+
+		portName := strings.Split(annotation, "/")[1]
+		path := field.NewPath("metadata").Child("annotations").Child(annotation)
+
+		if _, ok := definedClaims[portName]; !ok {
+			allErrs = append(allErrs, field.Invalid(path, value,
+				fmt.Sprintf("port %s does not have a claim annotation", portName)))
+			continue
+		}
+
+		containerName := value
+		protocol := corev1.ProtocolTCP
+		if parts := strings.SplitN(value, "/", 2); len(parts) == 2 {
+			containerName = parts[0]
+			protocol = corev1.Protocol(strings.ToUpper(parts[1]))
+		}
+
+		if protocol != corev1.ProtocolTCP && protocol != corev1.ProtocolUDP && protocol != corev1.ProtocolSCTP {
+			allErrs = append(allErrs, field.NotSupported(path, string(protocol),
+				[]string{string(corev1.ProtocolTCP), string(corev1.ProtocolUDP), string(corev1.ProtocolSCTP)}))
+			continue
+		}
+
+		if podHasPort(r, portName) {
+			continue
+		}
+
+		container := podContainer(r, containerName)
+		if container == nil {
+			allErrs = append(allErrs, field.NotFound(path, containerName))
+			continue
+		}
+
+		// the container port is set to the allocated port once the claim is resolved
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			Name:     portName,
+			Protocol: protocol,
+		})
+	}
+
 	// host ports must not be set
 	// if claims defined
 	//  all ports must be named
-	//  all ports must have unique names
-	portNames := make(map[string]struct{ containerIndex, portIndex int })
-	for containerIndex, container := range r.Spec.Containers {
-		for portIndex, port := range container.Ports {
-			path := field.NewPath("spec").Child("containers").Index(containerIndex).Child("ports").Index(portIndex).Child("name")
-			if len(definedClaims) > 0 {
-				if len(port.Name) == 0 {
-					allErrs = append(allErrs, field.Invalid(path, port.Name,
-						"Port name must be set"))
+	//  all ports must have unique names across containers, init containers and ephemeral containers
+	containerGroups := []struct {
+		path       *field.Path
+		containers []corev1.Container
+	}{
+		{path: field.NewPath("spec").Child("containers"), containers: r.Spec.Containers},
+		{path: field.NewPath("spec").Child("initContainers"), containers: r.Spec.InitContainers},
+	}
+
+	exemptPorts, err := w.exemptPorts(ctx, r)
+	if err != nil {
+		allErrs = append(allErrs, field.InternalError(field.NewPath("spec"), err))
+	}
+
+	type containerPort struct {
+		container *corev1.Container
+		port      *corev1.ContainerPort
+	}
+
+	portNames := make(map[string]containerPort)
+	for _, containerGroup := range containerGroups {
+		for containerIndex := range containerGroup.containers {
+			container := &containerGroup.containers[containerIndex]
+			for portIndex := range container.Ports {
+				port := &container.Ports[portIndex]
+				portPath := containerGroup.path.Index(containerIndex).Child("ports").Index(portIndex)
+
+				if len(definedClaims) > 0 {
+					if len(port.Name) == 0 {
+						allErrs = append(allErrs, field.Invalid(portPath.Child("name"), port.Name,
+							"Port name must be set"))
+					}
+
+					if _, ok := portNames[port.Name]; ok {
+						allErrs = append(allErrs, field.Duplicate(portPath.Child("name"), port.Name))
+					}
+					portNames[port.Name] = containerPort{container: container, port: port}
 				}
 
-				if _, ok := portNames[port.Name]; ok {
-					allErrs = append(allErrs, field.Duplicate(path, port.Name))
+				if _, ok := definedClaims[port.Name]; !ok && port.HostPort > 0 && portExempt(exemptPorts, port) == false {
+					allErrs = append(allErrs, field.Invalid(portPath.Child("hostPort"), port.HostPort,
+						"host ports cannot be set"))
 				}
-				portNames[port.Name] = struct{ containerIndex, portIndex int }{containerIndex: containerIndex, portIndex: portIndex}
 			}
+		}
+	}
+
+	// ephemeral containers can never be given a host port
+	for containerIndex := range r.Spec.EphemeralContainers {
+		container := &r.Spec.EphemeralContainers[containerIndex]
+		for portIndex := range container.Ports {
+			port := &container.Ports[portIndex]
+			portPath := field.NewPath("spec").Child("ephemeralContainers").Index(containerIndex).Child("ports").Index(portIndex)
 
-			if _, ok := definedClaims[port.Name]; !ok && port.HostPort > 0 {
-				allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("containers").Index(containerIndex).Child("ports").Index(portIndex).Child("hostPort"), port.HostPort,
+			if len(definedClaims) > 0 {
+				if _, ok := portNames[port.Name]; ok && len(port.Name) > 0 {
+					allErrs = append(allErrs, field.Duplicate(portPath.Child("name"), port.Name))
+				}
+			}
+
+			if port.HostPort > 0 {
+				allErrs = append(allErrs, field.Invalid(portPath.Child("hostPort"), port.HostPort,
 					"host ports cannot be set"))
 			}
 		}
 	}
 
+	if portMode, ok := r.Annotations[hostportv1alpha1.HostPortPodAnnotationPortMode]; ok {
+		if portMode != string(hostportv1alpha1.HostPortClaimPortModeMapped) && portMode != string(hostportv1alpha1.HostPortClaimPortModeIdentity) {
+			allErrs = append(allErrs, field.NotSupported(field.NewPath("metadata").Child("annotations").Child(hostportv1alpha1.HostPortPodAnnotationPortMode), portMode,
+				[]string{string(hostportv1alpha1.HostPortClaimPortModeMapped), string(hostportv1alpha1.HostPortClaimPortModeIdentity)}))
+		}
+	}
+
+	allocatedPorts := make(map[string]int)
 	for portName, claimName := range definedClaims {
 		if len(portName) == 0 || len(claimName) == 0 {
 			continue
@@ -126,28 +257,40 @@ func (w *PodWebhook) Default(ctx context.Context, obj runtime.Object) error {
 
 		path := field.NewPath("metadata").Child("annotations").Child(fmt.Sprintf("%s/%s", hostportv1alpha1.HostPortPodAnnotationClaimPrefix, portName))
 
+		if _, ok := portNames[portName]; !ok {
+			allErrs = append(allErrs, field.Invalid(path, claimName,
+				fmt.Sprintf("no container declares a port named %s", portName)))
+			continue
+		}
+
 		hpc := &hostportv1alpha1.HostPortClaim{}
-		err := w.client.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: claimName}, hpc)
+		err = w.client.Get(ctx, types.NamespacedName{Namespace: r.Namespace, Name: claimName}, hpc)
 		if err != nil {
 			if apierrors.IsNotFound(err) {
-				allErrs = append(allErrs, field.NotFound(path, claimName))
+				claimErrs = append(claimErrs, field.NotFound(path, claimName))
 			} else {
-				allErrs = append(allErrs, field.InternalError(path, err))
+				claimErrs = append(claimErrs, field.InternalError(path, err))
 			}
 			continue
 		}
 
+		if hpc.Status.Phase == hostportv1alpha1.HostPortClaimPhaseLost {
+			claimErrs = append(claimErrs, field.Invalid(path, claimName,
+				"hostPortClaim has lost its host port"))
+			continue
+		}
+
 		// if not bound and not deleting don't allow
 		// hpc can be deleting and still be usable (it won't go poof until all pods using it are gone)
 		if hpc.Status.Phase != hostportv1alpha1.HostPortClaimPhaseBound && hpc.Status.Phase != hostportv1alpha1.HostPortClaimPhaseDeleting {
-			allErrs = append(allErrs, field.Invalid(path, claimName,
+			claimErrs = append(claimErrs, field.Invalid(path, claimName,
 				"hostPortClaim is not bound to a host port yet"))
 			continue
 		}
 
 		// hpc doesn't have finalizer so it's about to go poof so we can't use it
 		if controllerutil.ContainsFinalizer(hpc, hostportv1alpha1.HostPortFinalizer) == false {
-			allErrs = append(allErrs, field.Invalid(path, claimName,
+			claimErrs = append(claimErrs, field.Invalid(path, claimName,
 				"hostPortClaim is deleting"))
 			continue
 		}
@@ -156,36 +299,227 @@ func (w *PodWebhook) Default(ctx context.Context, obj runtime.Object) error {
 		err = w.client.Get(ctx, types.NamespacedName{Name: hpc.Spec.HostPortName}, hp)
 		if err != nil {
 			if apierrors.IsNotFound(err) {
-				allErrs = append(allErrs, field.NotFound(path.Child("hostPort"), hpc.Spec.HostPortName))
+				claimErrs = append(claimErrs, field.NotFound(path.Child("hostPort"), hpc.Spec.HostPortName))
 			} else {
-				allErrs = append(allErrs, field.InternalError(path.Child("hostPort"), err))
+				claimErrs = append(claimErrs, field.InternalError(path.Child("hostPort"), err))
 			}
 			continue
 		}
 
 		if hp.Status.Phase != hostportv1alpha1.HostPortPhaseAllocated {
-			allErrs = append(allErrs, field.Invalid(path.Child("hostPort"), hp.Name,
+			claimErrs = append(claimErrs, field.Invalid(path.Child("hostPort"), hp.Name,
 				"hostport is not allocated a port yet"))
 			continue
 		}
 
 		if hp.Status.Port == 0 {
-			allErrs = append(allErrs, field.Invalid(path.Child("hostPort"), hp.Name,
+			claimErrs = append(claimErrs, field.Invalid(path.Child("hostPort"), hp.Name,
 				"hostport is not allocated a port yet"))
 			continue
 		}
 
-		if portLocation, ok := portNames[portName]; ok {
+		if location, ok := portNames[portName]; ok {
 			r.Annotations[hostportv1alpha1.HostPortPodAnnotationPortPrefix+"/"+portName] = strconv.Itoa(hp.Status.Port)
-			r.Spec.Containers[portLocation.containerIndex].Ports[portLocation.portIndex].HostPort = int32(hp.Status.Port)
+			location.port.HostPort = int32(hp.Status.Port)
+			allocatedPorts[portName] = hp.Status.Port
+
+			// port was added from the container annotation
+			if location.port.ContainerPort == 0 {
+				location.port.ContainerPort = int32(hp.Status.Port)
+			}
+
+			portMode := hpc.Spec.PortMode
+			if podPortMode, ok := r.Annotations[hostportv1alpha1.HostPortPodAnnotationPortMode]; ok {
+				portMode = hostportv1alpha1.HostPortClaimPortMode(podPortMode)
+			}
+
+			// host network pods must have the container port equal to the host port
+			if portMode == hostportv1alpha1.HostPortClaimPortModeIdentity || r.Spec.HostNetwork {
+				oldPort := location.port.ContainerPort
+				location.port.ContainerPort = int32(hp.Status.Port)
+
+				for _, probe := range []*corev1.Probe{location.container.LivenessProbe, location.container.ReadinessProbe, location.container.StartupProbe} {
+					setProbePort(probe, oldPort, int32(hp.Status.Port))
+				}
+			}
 		}
 	}
 
-	if len(allErrs) == 0 {
-		return nil
+	if value, ok := r.Annotations[hostportv1alpha1.HostPortPodAnnotationInjectEnv]; ok {
+		allErrs = append(allErrs, w.injectEnv(r, value, allocatedPorts)...)
 	}
 
-	return apierrors.NewInvalid(
-		schema.GroupKind{Group: "", Kind: r.Kind},
-		r.Name, allErrs)
+	return allErrs, claimErrs
+}
+
+// exemptPorts returns the host ports the pod is allowed to set by the HostPortExemptions matching it
+func (w *PodWebhook) exemptPorts(ctx context.Context, r *corev1.Pod) ([]hostportv1alpha1.HostPortExemptionPort, error) {
+	hpeList := &hostportv1alpha1.HostPortExemptionList{}
+	err := w.client.List(ctx, hpeList)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []hostportv1alpha1.HostPortExemptionPort
+	for _, hpe := range hpeList.Items {
+		// a broken exemption must not block every pod
+		matches, err := hpe.Matches(r)
+		if err != nil {
+			podlog.Error(err, "skipping invalid exemption", "hostportexemption", hpe.Name)
+			continue
+		}
+
+		if matches {
+			ports = append(ports, hpe.Spec.Ports...)
+		}
+	}
+
+	return ports, nil
+}
+
+// portExempt returns true if the container port's host port is in the exempted ports
+func portExempt(exemptPorts []hostportv1alpha1.HostPortExemptionPort, port *corev1.ContainerPort) bool {
+	for _, exemptPort := range exemptPorts {
+		if exemptPort.Exempts(port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// podHasClaims returns true if the pod has claim annotations
+func podHasClaims(r *corev1.Pod) bool {
+	for annotation := range r.Annotations {
+		if strings.HasPrefix(annotation, hostportv1alpha1.HostPortPodAnnotationClaimPrefix+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// podHasPort returns true if a container or init container declares a port with the name
+func podHasPort(r *corev1.Pod, portName string) bool {
+	for _, containers := range [][]corev1.Container{r.Spec.Containers, r.Spec.InitContainers} {
+		for _, container := range containers {
+			for _, port := range container.Ports {
+				if port.Name == portName {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// podContainer returns the container or init container with the name
+func podContainer(r *corev1.Pod, name string) *corev1.Container {
+	for _, containers := range [][]corev1.Container{r.Spec.Containers, r.Spec.InitContainers} {
+		for containerIndex := range containers {
+			if containers[containerIndex].Name == name {
+				return &containers[containerIndex]
+			}
+		}
+	}
+
+	return nil
+}
+
+// setProbePort changes the probe's port from oldPort to newPort when the probe refers to the port by number
+func setProbePort(probe *corev1.Probe, oldPort, newPort int32) {
+	if probe == nil {
+		return
+	}
+
+	if probe.HTTPGet != nil && probe.HTTPGet.Port.Type == intstr.Int && probe.HTTPGet.Port.IntVal == oldPort {
+		probe.HTTPGet.Port = intstr.FromInt32(newPort)
+	}
+
+	if probe.TCPSocket != nil && probe.TCPSocket.Port.Type == intstr.Int && probe.TCPSocket.Port.IntVal == oldPort {
+		probe.TCPSocket.Port = intstr.FromInt32(newPort)
+	}
+
+	if probe.GRPC != nil && probe.GRPC.Port == oldPort {
+		probe.GRPC.Port = newPort
+	}
+}
+
+// injectEnv adds HOSTPORT_<PORTNAME> environment variables for the allocated ports and
+// HOSTPORT_HOST_IP for the node IP to the containers selected by the inject env annotation
+func (w *PodWebhook) injectEnv(r *corev1.Pod, value string, allocatedPorts map[string]int) field.ErrorList {
+	var allErrs field.ErrorList
+	path := field.NewPath("metadata").Child("annotations").Child(hostportv1alpha1.HostPortPodAnnotationInjectEnv)
+
+	if value == "false" {
+		return allErrs
+	}
+
+	containerNames := make(map[string]bool)
+	if value != "true" {
+		for _, name := range strings.Split(value, ",") {
+			containerNames[strings.TrimSpace(name)] = false
+		}
+	}
+
+	portNames := make([]string, 0, len(allocatedPorts))
+	for portName := range allocatedPorts {
+		portNames = append(portNames, portName)
+	}
+	sort.Strings(portNames)
+
+	env := []corev1.EnvVar{
+		{
+			Name: "HOSTPORT_HOST_IP",
+			ValueFrom: &corev1.EnvVarSource{
+				FieldRef: &corev1.ObjectFieldSelector{
+					FieldPath: "status.hostIP",
+				},
+			},
+		},
+	}
+	for _, portName := range portNames {
+		env = append(env, corev1.EnvVar{
+			Name:  "HOSTPORT_" + strings.ToUpper(strings.ReplaceAll(portName, "-", "_")),
+			Value: strconv.Itoa(allocatedPorts[portName]),
+		})
+	}
+
+	for _, containers := range [][]corev1.Container{r.Spec.Containers, r.Spec.InitContainers} {
+		for containerIndex := range containers {
+			container := &containers[containerIndex]
+
+			if len(containerNames) > 0 {
+				if _, ok := containerNames[container.Name]; !ok {
+					continue
+				}
+				containerNames[container.Name] = true
+			}
+
+			for _, envVar := range env {
+				exists := false
+				for _, containerEnvVar := range container.Env {
+					if containerEnvVar.Name == envVar.Name {
+						exists = true
+						break
+					}
+				}
+
+				// don't override env vars set by the user
+				if exists == false {
+					container.Env = append(container.Env, envVar)
+				}
+			}
+		}
+	}
+
+	for name, found := range containerNames {
+		if found == false {
+			allErrs = append(allErrs, field.Invalid(path, value,
+				fmt.Sprintf("container %s does not exist", name)))
+		}
+	}
+
+	return allErrs
 }
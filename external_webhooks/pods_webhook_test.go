@@ -0,0 +1,613 @@
+package external_webhooks
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+	"github.com/rmb938/hostport-allocator/metrics"
+)
+
+func newFakeClient(objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = hostportv1alpha1.AddToScheme(scheme)
+
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+}
+
+func TestExemptionMatches(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-system", Name: "proxy", Labels: map[string]string{"app": "proxy"}},
+		Spec:       corev1.PodSpec{ServiceAccountName: "proxy"},
+	}
+
+	tests := []struct {
+		name     string
+		spec     hostportv1alpha1.HostPortExemptionSpec
+		expected bool
+		err      bool
+	}{
+		{
+			name:     "no criteria",
+			expected: false,
+		},
+		{
+			name:     "namespace",
+			spec:     hostportv1alpha1.HostPortExemptionSpec{Namespaces: []string{"kube-system"}},
+			expected: true,
+		},
+		{
+			name:     "other namespace",
+			spec:     hostportv1alpha1.HostPortExemptionSpec{Namespaces: []string{"default"}},
+			expected: false,
+		},
+		{
+			name: "service account",
+			spec: hostportv1alpha1.HostPortExemptionSpec{ServiceAccounts: []hostportv1alpha1.HostPortExemptionServiceAccount{
+				{Namespace: "kube-system", Name: "proxy"},
+			}},
+			expected: true,
+		},
+		{
+			name: "service account in another namespace",
+			spec: hostportv1alpha1.HostPortExemptionSpec{ServiceAccounts: []hostportv1alpha1.HostPortExemptionServiceAccount{
+				{Namespace: "default", Name: "proxy"},
+			}},
+			expected: false,
+		},
+		{
+			name: "every criteria must match",
+			spec: hostportv1alpha1.HostPortExemptionSpec{
+				Namespaces:  []string{"kube-system"},
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "dns"}},
+			},
+			expected: false,
+		},
+		{
+			name: "pod selector",
+			spec: hostportv1alpha1.HostPortExemptionSpec{
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "proxy"}},
+			},
+			expected: true,
+		},
+		{
+			name: "invalid pod selector",
+			spec: hostportv1alpha1.HostPortExemptionSpec{
+				PodSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: "Bogus"},
+				}},
+			},
+			err: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			matches, err := (&hostportv1alpha1.HostPortExemption{Spec: test.spec}).Matches(pod)
+			if (err != nil) != test.err {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if matches != test.expected {
+				t.Errorf("expected match to be %v", test.expected)
+			}
+		})
+	}
+}
+
+func TestDefaultPodExemptions(t *testing.T) {
+	exemption := &hostportv1alpha1.HostPortExemption{
+		ObjectMeta: metav1.ObjectMeta{Name: "proxy"},
+		Spec: hostportv1alpha1.HostPortExemptionSpec{
+			Namespaces: []string{"kube-system"},
+			Ports:      []hostportv1alpha1.HostPortExemptionPort{{Port: 80, Protocol: corev1.ProtocolTCP}, {Port: 53}},
+		},
+	}
+
+	// a broken exemption is skipped instead of denying every pod
+	broken := &hostportv1alpha1.HostPortExemption{
+		ObjectMeta: metav1.ObjectMeta{Name: "broken"},
+		Spec: hostportv1alpha1.HostPortExemptionSpec{
+			PodSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+				{Key: "app", Operator: "Bogus"},
+			}},
+			Ports: []hostportv1alpha1.HostPortExemptionPort{{Port: 443}},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		port      corev1.ContainerPort
+		valid     bool
+	}{
+		{
+			name:      "exempted port",
+			namespace: "kube-system",
+			port:      corev1.ContainerPort{ContainerPort: 80, HostPort: 80},
+			valid:     true,
+		},
+		{
+			name:      "exempted port with any protocol",
+			namespace: "kube-system",
+			port:      corev1.ContainerPort{ContainerPort: 53, HostPort: 53, Protocol: corev1.ProtocolUDP},
+			valid:     true,
+		},
+		{
+			name:      "exempted port with another protocol",
+			namespace: "kube-system",
+			port:      corev1.ContainerPort{ContainerPort: 80, HostPort: 80, Protocol: corev1.ProtocolUDP},
+			valid:     false,
+		},
+		{
+			name:      "port not exempted",
+			namespace: "kube-system",
+			port:      corev1.ContainerPort{ContainerPort: 8080, HostPort: 8080},
+			valid:     false,
+		},
+		{
+			name:      "pod not exempted",
+			namespace: "default",
+			port:      corev1.ContainerPort{ContainerPort: 80, HostPort: 80},
+			valid:     false,
+		},
+		{
+			name:      "no host port",
+			namespace: "default",
+			port:      corev1.ContainerPort{ContainerPort: 80},
+			valid:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &PodWebhook{client: newFakeClient(exemption, broken)}
+
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Namespace: test.namespace, Name: "pod"},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{{Name: "app", Ports: []corev1.ContainerPort{test.port}}},
+				},
+			}
+
+			allErrs, claimErrs := w.defaultPod(context.Background(), pod)
+			if len(claimErrs) > 0 {
+				t.Fatalf("unexpected claim errors %v", claimErrs)
+			}
+
+			if (len(allErrs) == 0) != test.valid {
+				t.Errorf("expected valid to be %v but got errors %v", test.valid, allErrs)
+			}
+		})
+	}
+}
+
+func TestDefaultDecisions(t *testing.T) {
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		counted   float64
+	}{
+		{name: "create", operation: admissionv1.Create, counted: 1},
+		{name: "update", operation: admissionv1.Update, counted: 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &PodWebhook{client: newFakeClient()}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "pod"}}
+
+			counter := metrics.PodWebhookDecisions.WithLabelValues(metrics.PodWebhookDecisionAllow, metrics.PodWebhookReasonNoClaims)
+			before := testutil.ToFloat64(counter)
+
+			ctx := admission.NewContextWithRequest(context.Background(), admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{Operation: test.operation},
+			})
+			err := w.Default(ctx, pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if counted := testutil.ToFloat64(counter) - before; counted != test.counted {
+				t.Errorf("expected %v decisions to be counted but got %v", test.counted, counted)
+			}
+		})
+	}
+}
+
+// boundClaim returns a claim bound to a HostPort allocated the port
+func boundClaim(name string, port int, portMode hostportv1alpha1.HostPortClaimPortMode) []client.Object {
+	return []client.Object{
+		&hostportv1alpha1.HostPortClaim{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, Finalizers: []string{hostportv1alpha1.HostPortFinalizer}},
+			Spec:       hostportv1alpha1.HostPortClaimSpec{HostPortClassName: "class", HostPortName: "hp-" + name, PortMode: portMode},
+			Status:     hostportv1alpha1.HostPortClaimStatus{Phase: hostportv1alpha1.HostPortClaimPhaseBound, Port: port},
+		},
+		&hostportv1alpha1.HostPort{
+			ObjectMeta: metav1.ObjectMeta{Name: "hp-" + name},
+			Spec:       hostportv1alpha1.HostPortSpec{HostPortClassName: "class"},
+			Status:     hostportv1alpha1.HostPortStatus{Phase: hostportv1alpha1.HostPortPhaseAllocated, Port: port},
+		},
+	}
+}
+
+// claimedPod returns a pod using the claim for the port named game
+func claimedPod(annotations map[string]string, containers ...corev1.Container) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   "default",
+			Name:        "pod",
+			Annotations: map[string]string{hostportv1alpha1.HostPortPodAnnotationClaimPrefix + "/game": "claim"},
+		},
+		Spec: corev1.PodSpec{Containers: containers},
+	}
+	for key, value := range annotations {
+		pod.Annotations[key] = value
+	}
+	return pod
+}
+
+func TestDefaultPodContainers(t *testing.T) {
+	always := corev1.ContainerRestartPolicyAlways
+	gamePort := corev1.ContainerPort{Name: "game", ContainerPort: 7777}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		hostPort func(pod *corev1.Pod) int32
+		errs     int
+	}{
+		{
+			name: "container",
+			pod:  claimedPod(nil, corev1.Container{Name: "app", Ports: []corev1.ContainerPort{gamePort}}),
+			hostPort: func(pod *corev1.Pod) int32 {
+				return pod.Spec.Containers[0].Ports[0].HostPort
+			},
+		},
+		{
+			name: "init container",
+			pod: func() *corev1.Pod {
+				pod := claimedPod(nil, corev1.Container{Name: "app"})
+				pod.Spec.InitContainers = []corev1.Container{{Name: "init", Ports: []corev1.ContainerPort{gamePort}}}
+				return pod
+			}(),
+			hostPort: func(pod *corev1.Pod) int32 {
+				return pod.Spec.InitContainers[0].Ports[0].HostPort
+			},
+		},
+		{
+			name: "sidecar",
+			pod: func() *corev1.Pod {
+				pod := claimedPod(nil, corev1.Container{Name: "app"})
+				pod.Spec.InitContainers = []corev1.Container{{Name: "sidecar", RestartPolicy: &always, Ports: []corev1.ContainerPort{gamePort}}}
+				return pod
+			}(),
+			hostPort: func(pod *corev1.Pod) int32 {
+				return pod.Spec.InitContainers[0].Ports[0].HostPort
+			},
+		},
+		{
+			name: "duplicate port names across containers",
+			pod: func() *corev1.Pod {
+				pod := claimedPod(nil, corev1.Container{Name: "app", Ports: []corev1.ContainerPort{gamePort}})
+				pod.Spec.InitContainers = []corev1.Container{{Name: "init", Ports: []corev1.ContainerPort{gamePort}}}
+				return pod
+			}(),
+			errs: 1,
+		},
+		{
+			name: "ephemeral container host port",
+			pod: func() *corev1.Pod {
+				pod := claimedPod(nil, corev1.Container{Name: "app", Ports: []corev1.ContainerPort{gamePort}})
+				pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+					Name: "debug", Ports: []corev1.ContainerPort{{Name: "debug", ContainerPort: 8080, HostPort: 8080}},
+				}}}
+				return pod
+			}(),
+			errs: 1,
+		},
+		{
+			name: "ephemeral container claimed port name",
+			pod: func() *corev1.Pod {
+				pod := claimedPod(nil, corev1.Container{Name: "app", Ports: []corev1.ContainerPort{gamePort}})
+				pod.Spec.EphemeralContainers = []corev1.EphemeralContainer{{EphemeralContainerCommon: corev1.EphemeralContainerCommon{
+					Name: "debug", Ports: []corev1.ContainerPort{gamePort},
+				}}}
+				return pod
+			}(),
+			errs: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &PodWebhook{client: newFakeClient(boundClaim("claim", 9000, "")...)}
+
+			allErrs, claimErrs := w.defaultPod(context.Background(), test.pod)
+			if len(claimErrs) > 0 {
+				t.Fatalf("unexpected claim errors %v", claimErrs)
+			}
+
+			if len(allErrs) != test.errs {
+				t.Fatalf("expected %d errors but got %v", test.errs, allErrs)
+			}
+
+			if test.hostPort != nil {
+				if hostPort := test.hostPort(test.pod); hostPort != 9000 {
+					t.Errorf("expected host port 9000 but got %d", hostPort)
+				}
+
+				if test.pod.Annotations[hostportv1alpha1.HostPortPodAnnotationPortPrefix+"/game"] != "9000" {
+					t.Errorf("expected the port annotation to be set")
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultPodInjectEnv(t *testing.T) {
+	hostIP := corev1.EnvVar{Name: "HOSTPORT_HOST_IP", ValueFrom: &corev1.EnvVarSource{FieldRef: &corev1.ObjectFieldSelector{FieldPath: "status.hostIP"}}}
+	gameEnv := corev1.EnvVar{Name: "HOSTPORT_GAME_PORT", Value: "9000"}
+	userEnv := corev1.EnvVar{Name: "HOSTPORT_GAME_PORT", Value: "1234"}
+
+	tests := []struct {
+		name     string
+		inject   string
+		userEnv  bool
+		expected map[string][]corev1.EnvVar
+		errs     int
+	}{
+		{
+			name:     "all containers",
+			inject:   "true",
+			expected: map[string][]corev1.EnvVar{"app": {hostIP, gameEnv}, "sidecar": {hostIP, gameEnv}},
+		},
+		{
+			name:     "selected containers",
+			inject:   "sidecar",
+			expected: map[string][]corev1.EnvVar{"app": nil, "sidecar": {hostIP, gameEnv}},
+		},
+		{
+			name:     "disabled",
+			inject:   "false",
+			expected: map[string][]corev1.EnvVar{"app": nil, "sidecar": nil},
+		},
+		{
+			name:     "user env is kept",
+			inject:   "app",
+			userEnv:  true,
+			expected: map[string][]corev1.EnvVar{"app": {userEnv, hostIP}, "sidecar": nil},
+		},
+		{
+			name:     "missing container",
+			inject:   "app, missing",
+			expected: map[string][]corev1.EnvVar{"app": {hostIP, gameEnv}, "sidecar": nil},
+			errs:     1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &PodWebhook{client: newFakeClient(boundClaim("claim", 9000, "")...)}
+
+			app := corev1.Container{Name: "app", Ports: []corev1.ContainerPort{{Name: "game-port", ContainerPort: 7777}}}
+			if test.userEnv {
+				app.Env = []corev1.EnvVar{userEnv}
+			}
+
+			pod := claimedPod(map[string]string{hostportv1alpha1.HostPortPodAnnotationInjectEnv: test.inject}, app, corev1.Container{Name: "sidecar"})
+			// the port name is used in the variable name with dashes replaced
+			pod.Annotations[hostportv1alpha1.HostPortPodAnnotationClaimPrefix+"/game-port"] = "claim"
+			delete(pod.Annotations, hostportv1alpha1.HostPortPodAnnotationClaimPrefix+"/game")
+
+			allErrs, claimErrs := w.defaultPod(context.Background(), pod)
+			if len(claimErrs) > 0 {
+				t.Fatalf("unexpected claim errors %v", claimErrs)
+			}
+
+			if len(allErrs) != test.errs {
+				t.Fatalf("expected %d errors but got %v", test.errs, allErrs)
+			}
+
+			for _, container := range pod.Spec.Containers {
+				if reflect.DeepEqual(container.Env, test.expected[container.Name]) == false {
+					t.Errorf("unexpected env of container %s %v", container.Name, container.Env)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultPodPortMode(t *testing.T) {
+	tests := []struct {
+		name          string
+		claimMode     hostportv1alpha1.HostPortClaimPortMode
+		podMode       string
+		hostNetwork   bool
+		containerPort int32
+		probePort     int32
+		errs          int
+	}{
+		{
+			name:          "mapped",
+			containerPort: 7777,
+			probePort:     7777,
+		},
+		{
+			name:          "identity claim",
+			claimMode:     hostportv1alpha1.HostPortClaimPortModeIdentity,
+			containerPort: 9000,
+			probePort:     9000,
+		},
+		{
+			name:          "pod annotation overrides the claim",
+			claimMode:     hostportv1alpha1.HostPortClaimPortModeIdentity,
+			podMode:       string(hostportv1alpha1.HostPortClaimPortModeMapped),
+			containerPort: 7777,
+			probePort:     7777,
+		},
+		{
+			name:          "identity pod annotation",
+			podMode:       string(hostportv1alpha1.HostPortClaimPortModeIdentity),
+			containerPort: 9000,
+			probePort:     9000,
+		},
+		{
+			name:          "host network is always identity",
+			hostNetwork:   true,
+			containerPort: 9000,
+			probePort:     9000,
+		},
+		{
+			name:          "unsupported pod annotation",
+			podMode:       "Random",
+			containerPort: 7777,
+			probePort:     7777,
+			errs:          1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &PodWebhook{client: newFakeClient(boundClaim("claim", 9000, test.claimMode)...)}
+
+			annotations := map[string]string{}
+			if len(test.podMode) > 0 {
+				annotations[hostportv1alpha1.HostPortPodAnnotationPortMode] = test.podMode
+			}
+
+			pod := claimedPod(annotations, corev1.Container{
+				Name:  "app",
+				Ports: []corev1.ContainerPort{{Name: "game", ContainerPort: 7777}},
+				ReadinessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{
+					TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt32(7777)},
+				}},
+				// probes using other ports are left alone
+				LivenessProbe: &corev1.Probe{ProbeHandler: corev1.ProbeHandler{
+					HTTPGet: &corev1.HTTPGetAction{Port: intstr.FromInt32(8080)},
+				}},
+			})
+			pod.Spec.HostNetwork = test.hostNetwork
+
+			allErrs, claimErrs := w.defaultPod(context.Background(), pod)
+			if len(claimErrs) > 0 {
+				t.Fatalf("unexpected claim errors %v", claimErrs)
+			}
+
+			if len(allErrs) != test.errs {
+				t.Fatalf("expected %d errors but got %v", test.errs, allErrs)
+			}
+
+			container := pod.Spec.Containers[0]
+			if container.Ports[0].HostPort != 9000 {
+				t.Errorf("expected host port 9000 but got %d", container.Ports[0].HostPort)
+			}
+
+			if container.Ports[0].ContainerPort != test.containerPort {
+				t.Errorf("expected container port %d but got %d", test.containerPort, container.Ports[0].ContainerPort)
+			}
+
+			if container.ReadinessProbe.TCPSocket.Port.IntVal != test.probePort {
+				t.Errorf("expected probe port %d but got %d", test.probePort, container.ReadinessProbe.TCPSocket.Port.IntVal)
+			}
+
+			if container.LivenessProbe.HTTPGet.Port.IntVal != 8080 {
+				t.Errorf("expected unrelated probe port to be kept but got %d", container.LivenessProbe.HTTPGet.Port.IntVal)
+			}
+		})
+	}
+}
+
+func TestDefaultPodClaimAnnotations(t *testing.T) {
+	containerAnnotation := hostportv1alpha1.HostPortPodAnnotationContainerPrefix + "/game"
+
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		ports       []corev1.ContainerPort
+		expected    []corev1.ContainerPort
+		errs        int
+	}{
+		{
+			name:     "declared port",
+			ports:    []corev1.ContainerPort{{Name: "game", ContainerPort: 7777}},
+			expected: []corev1.ContainerPort{{Name: "game", ContainerPort: 7777, HostPort: 9000}},
+		},
+		{
+			name:  "unmatched claim annotation",
+			ports: []corev1.ContainerPort{{Name: "other", ContainerPort: 7777}},
+			errs:  1,
+		},
+		{
+			name:  "unnamed port",
+			ports: []corev1.ContainerPort{{Name: "game", ContainerPort: 7777}, {ContainerPort: 8080}},
+			errs:  1,
+		},
+		{
+			name:        "port added to the container",
+			annotations: map[string]string{containerAnnotation: "app"},
+			expected:    []corev1.ContainerPort{{Name: "game", ContainerPort: 9000, HostPort: 9000, Protocol: corev1.ProtocolTCP}},
+		},
+		{
+			name:        "port added with a protocol",
+			annotations: map[string]string{containerAnnotation: "app/udp"},
+			expected:    []corev1.ContainerPort{{Name: "game", ContainerPort: 9000, HostPort: 9000, Protocol: corev1.ProtocolUDP}},
+		},
+		{
+			name:        "declared port is not added again",
+			annotations: map[string]string{containerAnnotation: "app"},
+			ports:       []corev1.ContainerPort{{Name: "game", ContainerPort: 7777}},
+			expected:    []corev1.ContainerPort{{Name: "game", ContainerPort: 7777, HostPort: 9000}},
+		},
+		{
+			name:        "unsupported protocol",
+			annotations: map[string]string{containerAnnotation: "app/icmp"},
+			errs:        2,
+		},
+		{
+			name:        "missing container",
+			annotations: map[string]string{containerAnnotation: "missing"},
+			errs:        2,
+		},
+		{
+			name:        "container annotation without a claim",
+			annotations: map[string]string{hostportv1alpha1.HostPortPodAnnotationContainerPrefix + "/other": "app"},
+			ports:       []corev1.ContainerPort{{Name: "game", ContainerPort: 7777}},
+			errs:        1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &PodWebhook{client: newFakeClient(boundClaim("claim", 9000, "")...)}
+
+			pod := claimedPod(test.annotations, corev1.Container{Name: "app", Ports: test.ports})
+
+			allErrs, claimErrs := w.defaultPod(context.Background(), pod)
+			if len(claimErrs) > 0 {
+				t.Fatalf("unexpected claim errors %v", claimErrs)
+			}
+
+			if len(allErrs) != test.errs {
+				t.Fatalf("expected %d errors but got %v", test.errs, allErrs)
+			}
+
+			if test.expected != nil && reflect.DeepEqual(pod.Spec.Containers[0].Ports, test.expected) == false {
+				t.Errorf("unexpected ports %v", pod.Spec.Containers[0].Ports)
+			}
+		})
+	}
+}
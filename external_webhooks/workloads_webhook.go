@@ -0,0 +1,158 @@
+package external_webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var workloadlog = logf.Log.WithName("workload-resource")
+
+// WorkloadWebhook runs the pod checks against the pod template of workloads
+// so broken manifests are reported when they are applied instead of when pods fail to be created
+type WorkloadWebhook struct {
+	client  client.Client
+	decoder admission.Decoder
+}
+
+func (w *WorkloadWebhook) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	hookServer := mgr.GetWebhookServer()
+
+	hookServer.Register("/validate-workloads", &webhook.Admission{Handler: &WorkloadWebhook{client: mgr.GetClient(), decoder: admission.NewDecoder(mgr.GetScheme())}})
+
+	return nil
+}
+
+var _ admission.Handler = &WorkloadWebhook{}
+
+func (w *WorkloadWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	template, templatePath, err := w.podTemplate(req, req.Object.Raw)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if template == nil {
+		return admission.Allowed("")
+	}
+
+	if req.Operation == admissionv1.Update {
+		oldTemplate, _, err := w.podTemplate(req, req.OldObject.Raw)
+		if err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+
+		// don't block unrelated updates to workloads that already exist
+		if oldTemplate != nil && equality.Semantic.DeepEqual(oldTemplate, template) {
+			return admission.Allowed("")
+		}
+	}
+
+	workloadlog.Info("validate", "kind", req.Kind.Kind, "name", req.Name, "namespace", req.Namespace)
+
+	// the pod is only used for validation, any changes made to it are discarded
+	pod := &corev1.Pod{
+		ObjectMeta: *template.ObjectMeta.DeepCopy(),
+		Spec:       *template.Spec.DeepCopy(),
+	}
+	pod.Namespace = req.Namespace
+
+	podWebhook := &PodWebhook{client: w.client}
+	allErrs, claimErrs := podWebhook.defaultPod(ctx, pod)
+
+	if len(allErrs) > 0 {
+		err = apierrors.NewInvalid(
+			schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind},
+			req.Name, prefixErrors(templatePath, allErrs))
+		return admission.Denied(err.Error())
+	}
+
+	// claims may become usable before pods are created so their state only warns
+	var warnings []string
+	for _, claimErr := range prefixErrors(templatePath, claimErrs) {
+		warnings = append(warnings, claimErr.Error())
+	}
+
+	return admission.Allowed("").WithWarnings(warnings...)
+}
+
+// podTemplate decodes the workload in the request and returns its pod template and the path to it
+func (w *WorkloadWebhook) podTemplate(req admission.Request, raw []byte) (*corev1.PodTemplateSpec, *field.Path, error) {
+	if len(raw) == 0 {
+		return nil, nil, nil
+	}
+
+	templatePath := field.NewPath("spec").Child("template")
+
+	groupKind := schema.GroupKind{Group: req.Kind.Group, Kind: req.Kind.Kind}
+
+	switch groupKind {
+	case schema.GroupKind{Group: appsv1.GroupName, Kind: "Deployment"}:
+		obj := &appsv1.Deployment{}
+		if err := w.decoder.DecodeRaw(runtime.RawExtension{Raw: raw}, obj); err != nil {
+			return nil, nil, err
+		}
+		return &obj.Spec.Template, templatePath, nil
+	case schema.GroupKind{Group: appsv1.GroupName, Kind: "ReplicaSet"}:
+		obj := &appsv1.ReplicaSet{}
+		if err := w.decoder.DecodeRaw(runtime.RawExtension{Raw: raw}, obj); err != nil {
+			return nil, nil, err
+		}
+		return &obj.Spec.Template, templatePath, nil
+	case schema.GroupKind{Group: appsv1.GroupName, Kind: "StatefulSet"}:
+		obj := &appsv1.StatefulSet{}
+		if err := w.decoder.DecodeRaw(runtime.RawExtension{Raw: raw}, obj); err != nil {
+			return nil, nil, err
+		}
+		return &obj.Spec.Template, templatePath, nil
+	case schema.GroupKind{Group: appsv1.GroupName, Kind: "DaemonSet"}:
+		obj := &appsv1.DaemonSet{}
+		if err := w.decoder.DecodeRaw(runtime.RawExtension{Raw: raw}, obj); err != nil {
+			return nil, nil, err
+		}
+		return &obj.Spec.Template, templatePath, nil
+	case schema.GroupKind{Group: batchv1.GroupName, Kind: "Job"}:
+		obj := &batchv1.Job{}
+		if err := w.decoder.DecodeRaw(runtime.RawExtension{Raw: raw}, obj); err != nil {
+			return nil, nil, err
+		}
+		return &obj.Spec.Template, templatePath, nil
+	case schema.GroupKind{Group: batchv1.GroupName, Kind: "CronJob"}:
+		obj := &batchv1.CronJob{}
+		if err := w.decoder.DecodeRaw(runtime.RawExtension{Raw: raw}, obj); err != nil {
+			return nil, nil, err
+		}
+		return &obj.Spec.JobTemplate.Spec.Template, field.NewPath("spec").Child("jobTemplate").Child("spec").Child("template"), nil
+	}
+
+	return nil, nil, fmt.Errorf("unsupported kind %s", req.Kind.String())
+}
+
+// prefixErrors moves errors found on the pod to the location of the pod template in the workload
+func prefixErrors(templatePath *field.Path, errs field.ErrorList) field.ErrorList {
+	var prefixed field.ErrorList
+	for _, err := range errs {
+		prefixedErr := *err
+		prefixedErr.Field = templatePath.String() + "." + err.Field
+		prefixed = append(prefixed, &prefixedErr)
+	}
+	return prefixed
+}
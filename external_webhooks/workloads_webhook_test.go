@@ -0,0 +1,151 @@
+package external_webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+func TestWorkloadWebhookHandle(t *testing.T) {
+	template := func(claimName string, hostPort int32) corev1.PodTemplateSpec {
+		return corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Annotations: map[string]string{hostportv1alpha1.HostPortPodAnnotationClaimPrefix + "/game": claimName},
+			},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  "app",
+				Ports: []corev1.ContainerPort{{Name: "game", ContainerPort: 7777}, {Name: "metrics", ContainerPort: 8080, HostPort: hostPort}},
+			}}},
+		}
+	}
+
+	deployment := func(t corev1.PodTemplateSpec) runtime.Object {
+		return &appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "workload"},
+			Spec:       appsv1.DeploymentSpec{Template: t},
+		}
+	}
+
+	cronJob := func(t corev1.PodTemplateSpec) runtime.Object {
+		return &batchv1.CronJob{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "workload"},
+			Spec:       batchv1.CronJobSpec{JobTemplate: batchv1.JobTemplateSpec{Spec: batchv1.JobSpec{Template: t}}},
+		}
+	}
+
+	deploymentKind := metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	cronJobKind := metav1.GroupVersionKind{Group: "batch", Version: "v1", Kind: "CronJob"}
+
+	tests := []struct {
+		name      string
+		operation admissionv1.Operation
+		kind      metav1.GroupVersionKind
+		old       runtime.Object
+		obj       runtime.Object
+		allowed   bool
+		message   string
+		warnings  int
+	}{
+		{
+			name:      "valid",
+			operation: admissionv1.Create,
+			kind:      deploymentKind,
+			obj:       deployment(template("claim", 0)),
+			allowed:   true,
+		},
+		{
+			name:      "host port",
+			operation: admissionv1.Create,
+			kind:      deploymentKind,
+			obj:       deployment(template("claim", 8080)),
+			allowed:   false,
+			message:   "spec.template.spec.containers[0].ports[1].hostPort",
+		},
+		{
+			name:      "host port in a cron job",
+			operation: admissionv1.Create,
+			kind:      cronJobKind,
+			obj:       cronJob(template("claim", 8080)),
+			allowed:   false,
+			message:   "spec.jobTemplate.spec.template.spec.containers[0].ports[1].hostPort",
+		},
+		{
+			name:      "missing claim only warns",
+			operation: admissionv1.Create,
+			kind:      deploymentKind,
+			obj:       deployment(template("missing", 0)),
+			allowed:   true,
+			warnings:  1,
+		},
+		{
+			name:      "unchanged template is not checked",
+			operation: admissionv1.Update,
+			kind:      deploymentKind,
+			old:       deployment(template("claim", 8080)),
+			obj:       deployment(template("claim", 8080)),
+			allowed:   true,
+		},
+		{
+			name:      "changed template is checked",
+			operation: admissionv1.Update,
+			kind:      deploymentKind,
+			old:       deployment(template("claim", 0)),
+			obj:       deployment(template("claim", 8080)),
+			allowed:   false,
+			message:   "spec.template.spec.containers[0].ports[1].hostPort",
+		},
+		{
+			name:      "delete",
+			operation: admissionv1.Delete,
+			kind:      deploymentKind,
+			allowed:   true,
+		},
+	}
+
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := &WorkloadWebhook{client: newFakeClient(boundClaim("claim", 9000, "")...), decoder: admission.NewDecoder(scheme)}
+
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: test.operation,
+				Kind:      test.kind,
+				Namespace: "default",
+				Name:      "workload",
+			}}
+			if test.obj != nil {
+				req.Object.Raw, _ = json.Marshal(test.obj)
+			}
+			if test.old != nil {
+				req.OldObject.Raw, _ = json.Marshal(test.old)
+			}
+
+			resp := w.Handle(context.Background(), req)
+			if resp.Allowed != test.allowed {
+				t.Fatalf("expected allowed to be %v but got %v", test.allowed, resp.Result)
+			}
+
+			if len(test.message) > 0 && strings.Contains(resp.Result.Message, test.message) == false {
+				t.Errorf("expected the error to point at %s but got %s", test.message, resp.Result.Message)
+			}
+
+			if len(resp.Warnings) != test.warnings {
+				t.Errorf("expected %d warnings but got %v", test.warnings, resp.Warnings)
+			}
+		})
+	}
+}
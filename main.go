@@ -17,19 +17,27 @@ limitations under the License.
 package main
 
 import (
+	"errors"
 	"flag"
 	"os"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
+	"github.com/rmb938/hostport-allocator/agent"
 	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
 	"github.com/rmb938/hostport-allocator/controllers"
 	"github.com/rmb938/hostport-allocator/external_webhooks"
+	"github.com/rmb938/hostport-allocator/metrics"
+	"github.com/rmb938/hostport-allocator/scheduler"
 	"github.com/rmb938/hostport-allocator/webhook"
 	metricsserver "sigs.k8s.io/controller-runtime/pkg/metrics/server"
 	// +kubebuilder:scaffold:imports
@@ -50,23 +58,57 @@ func init() {
 func main() {
 	var healthAddr string
 	var metricsAddr string
+	var schedulerExtenderAddr string
+	var enableExternalDNS bool
 	var enableLeaderElection bool
+	var agentMode bool
+	var nodeName string
+	var reservedPortsPath string
+	var procRoot string
+	var firewallRulesetPath string
+	var nftPath string
 	flag.StringVar(&healthAddr, "health-addr", ":8081", "The address the health endpoints binds to.")
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&schedulerExtenderAddr, "scheduler-extender-addr", "", "The address the scheduler extender binds to. "+
+		"The scheduler extender is disabled when empty.")
+	flag.BoolVar(&enableExternalDNS, "enable-external-dns", false, "Publish ExternalDNS DNSEndpoints for claims. "+
+		"Requires the ExternalDNS DNSEndpoint CRD to be installed.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&agentMode, "agent", false, "Run as the node agent instead of the controller manager.")
+	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"), "The name of the node the agent is running on.")
+	flag.StringVar(&reservedPortsPath, "reserved-ports-path", agent.DefaultReservedPortsPath,
+		"The path of the net.ipv4.ip_local_reserved_ports sysctl file the agent keeps in sync.")
+	flag.StringVar(&firewallRulesetPath, "firewall-ruleset-path", "", "Write the agent's nftables ruleset to this file when set.")
+	flag.StringVar(&nftPath, "nft-path", "", "Apply the agent's nftables ruleset with the nft binary at this path when set.")
+	flag.StringVar(&procRoot, "proc-root", agent.DefaultProcRoot, "The root of the proc filesystem the agent scans for sockets.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
 
+	// the agent only needs the pods on its node
+	var cacheOptions cache.Options
+	var clientOptions client.Options
+	if agentMode {
+		cacheOptions.ByObject = map[client.Object]cache.ByObject{
+			&corev1.Pod{}: {Field: fields.OneTermEqualSelector("spec.nodeName", nodeName)},
+		}
+		// the agent is only allowed to get its own node, so nodes are read without a watch
+		clientOptions.Cache = &client.CacheOptions{
+			DisableFor: []client.Object{&corev1.Node{}},
+		}
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme: scheme,
+		Cache:  cacheOptions,
+		Client: clientOptions,
 		Metrics: metricsserver.Options{
 			BindAddress: metricsAddr,
 		},
 		HealthProbeBindAddress: healthAddr,
-		LeaderElection:         enableLeaderElection,
+		LeaderElection:         enableLeaderElection && agentMode == false,
 		LeaderElectionID:       "f10832af.rmb938.com",
 	})
 	if err != nil {
@@ -86,10 +128,36 @@ func main() {
 		os.Exit(1)
 	}
 
+	if agentMode {
+		setupAgent(mgr, nodeName, reservedPortsPath, procRoot, firewallRulesetPath, nftPath)
+	} else {
+		setupController(mgr, schedulerExtenderAddr, enableExternalDNS)
+	}
+	// +kubebuilder:scaffold:builder
+
+	signalHandler := ctrl.SetupSignalHandler()
+
+	setupLog.Info("starting manager")
+	if err := mgr.Start(signalHandler); err != nil {
+		setupLog.Error(err, "problem running manager")
+		os.Exit(1)
+	}
+}
+
+// setupController adds the controllers and webhooks of the controller manager
+func setupController(mgr ctrl.Manager, schedulerExtenderAddr string, enableExternalDNS bool) {
+	var err error
+
+	if err = metrics.SetupCollectorWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to register metrics collector")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.HostPortClassReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("HostPortClass"),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("HostPortClass"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("hostportclass-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HostPortClass")
 		os.Exit(1)
@@ -101,9 +169,10 @@ func main() {
 	}
 
 	if err = (&controllers.HostPortClaimReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("HostPortClaim"),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("HostPortClaim"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("hostportclaim-controller"),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HostPortClaim")
 		os.Exit(1)
@@ -114,29 +183,113 @@ func main() {
 	}
 
 	if err = (&controllers.HostPortReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("HostPort"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("hostport-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HostPort")
+		os.Exit(1)
+	}
+	if err = (&controllers.HostPortOwnerReconciler{
 		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("HostPort"),
+		Log:    ctrl.Log.WithName("controllers").WithName("HostPortOwner"),
 		Scheme: mgr.GetScheme(),
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "HostPort")
+		setupLog.Error(err, "unable to create controller", "controller", "HostPortOwner")
+		os.Exit(1)
+	}
+	if err = (&controllers.HostPortClaimServiceReconciler{
+		Client: mgr.GetClient(),
+		Log:    ctrl.Log.WithName("controllers").WithName("HostPortClaimService"),
+		Scheme: mgr.GetScheme(),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "HostPortClaimService")
 		os.Exit(1)
 	}
+	if enableExternalDNS {
+		if err = (&controllers.HostPortClaimDNSReconciler{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("controllers").WithName("HostPortClaimDNS"),
+			Scheme: mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "HostPortClaimDNS")
+			os.Exit(1)
+		}
+	}
 	if err = webhook.SetupHostPortWebhookWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "HostPort")
 		os.Exit(1)
 	}
+	if err = webhook.SetupHostPortExemptionWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "HostPortExemption")
+		os.Exit(1)
+	}
 
 	if err = (&external_webhooks.PodWebhook{}).SetupWebhookWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create webhook", "webhook", "Pod")
 		os.Exit(1)
 	}
-	// +kubebuilder:scaffold:builder
+	if err = (&external_webhooks.PodValidatingWebhook{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "PodValidating")
+		os.Exit(1)
+	}
+	if err = (&external_webhooks.WorkloadWebhook{}).SetupWebhookWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create webhook", "webhook", "Workload")
+		os.Exit(1)
+	}
 
-	signalHandler := ctrl.SetupSignalHandler()
+	if len(schedulerExtenderAddr) > 0 {
+		if err = (&scheduler.Extender{
+			Client:      mgr.GetClient(),
+			Log:         ctrl.Log.WithName("scheduler").WithName("Extender"),
+			BindAddress: schedulerExtenderAddr,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create scheduler extender")
+			os.Exit(1)
+		}
+	}
+}
 
-	setupLog.Info("starting manager")
-	if err := mgr.Start(signalHandler); err != nil {
-		setupLog.Error(err, "problem running manager")
+// setupAgent adds the controllers of the node agent
+func setupAgent(mgr ctrl.Manager, nodeName string, reservedPortsPath string, procRoot string, firewallRulesetPath string, nftPath string) {
+	if len(nodeName) == 0 {
+		setupLog.Error(errors.New("node name is empty"), "unable to run agent, set --node-name or NODE_NAME")
+		os.Exit(1)
+	}
+
+	if err := (&agent.ReservedPortsReconciler{
+		Client:            mgr.GetClient(),
+		Log:               ctrl.Log.WithName("agent").WithName("ReservedPorts"),
+		Scheme:            mgr.GetScheme(),
+		NodeName:          nodeName,
+		ReservedPortsPath: reservedPortsPath,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ReservedPorts")
+		os.Exit(1)
+	}
+
+	if err := (&agent.PortConflictReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("agent").WithName("PortConflict"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("hostport-agent"),
+		NodeName: nodeName,
+		ProcRoot: procRoot,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "PortConflict")
+		os.Exit(1)
+	}
+
+	if err := (&agent.FirewallReconciler{
+		Client:      mgr.GetClient(),
+		Log:         ctrl.Log.WithName("agent").WithName("Firewall"),
+		Scheme:      mgr.GetScheme(),
+		NodeName:    nodeName,
+		RulesetPath: firewallRulesetPath,
+		NFTPath:     nftPath,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Firewall")
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,91 @@
+package metrics
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+var (
+	classCapacityDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "class", "capacity_ports"),
+		"Number of ports in the pools of a HostPortClass.", []string{"class"}, nil)
+	classAllocatedDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "class", "allocated_ports"),
+		"Number of ports in the pools of a HostPortClass allocated to HostPorts.", []string{"class"}, nil)
+	classFreeDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "class", "free_ports"),
+		"Number of ports in the pools of a HostPortClass that are free.", []string{"class"}, nil)
+	claimsDesc = prometheus.NewDesc(prometheus.BuildFQName(namespace, "", "claims"),
+		"Number of HostPortClaims by phase.", []string{"phase"}, nil)
+)
+
+// Collector collects the usage of HostPortClasses and the phases of HostPortClaims from the manager's cache
+type Collector struct {
+	client client.Reader
+}
+
+// SetupCollectorWithManager registers the Collector on the controller-runtime metrics registry
+func SetupCollectorWithManager(mgr ctrl.Manager) error {
+	return metrics.Registry.Register(&Collector{client: mgr.GetClient()})
+}
+
+var _ prometheus.Collector = &Collector{}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- classCapacityDesc
+	ch <- classAllocatedDesc
+	ch <- classFreeDesc
+	ch <- claimsDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	ctx := context.Background()
+
+	hostPortClassList := &hostportv1alpha1.HostPortClassList{}
+	err := c.client.List(ctx, hostPortClassList)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(classCapacityDesc, err)
+	} else {
+		for _, hpcl := range hostPortClassList.Items {
+			capacity := 0
+			allocated := 0
+			for _, pool := range hpcl.Status.Pools {
+				capacity += pool.Capacity
+				allocated += pool.Allocated
+			}
+
+			ch <- prometheus.MustNewConstMetric(classCapacityDesc, prometheus.GaugeValue, float64(capacity), hpcl.Name)
+			ch <- prometheus.MustNewConstMetric(classAllocatedDesc, prometheus.GaugeValue, float64(allocated), hpcl.Name)
+			ch <- prometheus.MustNewConstMetric(classFreeDesc, prometheus.GaugeValue, float64(capacity-allocated), hpcl.Name)
+		}
+	}
+
+	hostPortClaimList := &hostportv1alpha1.HostPortClaimList{}
+	err = c.client.List(ctx, hostPortClaimList)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(claimsDesc, err)
+		return
+	}
+
+	phases := map[hostportv1alpha1.HostPortClaimStatusPhase]int{
+		hostportv1alpha1.HostPortClaimPhasePending:  0,
+		hostportv1alpha1.HostPortClaimPhaseBound:    0,
+		hostportv1alpha1.HostPortClaimPhaseReleased: 0,
+		hostportv1alpha1.HostPortClaimPhaseLost:     0,
+		hostportv1alpha1.HostPortClaimPhaseDeleting: 0,
+	}
+	for _, hpc := range hostPortClaimList.Items {
+		phase := hpc.Status.Phase
+		if len(phase) == 0 {
+			phase = hostportv1alpha1.HostPortClaimPhasePending
+		}
+		phases[phase]++
+	}
+
+	for phase, count := range phases {
+		ch <- prometheus.MustNewConstMetric(claimsDesc, prometheus.GaugeValue, float64(count), string(phase))
+	}
+}
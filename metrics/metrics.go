@@ -0,0 +1,54 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const namespace = "hostport"
+
+// reasons for allocation failures
+const (
+	AllocationFailureClassNotFound = "ClassNotFound"
+	AllocationFailureNoFreePorts   = "NoFreePorts"
+	AllocationFailureError         = "Error"
+)
+
+// decisions and reasons of the pod webhook
+const (
+	PodWebhookDecisionAllow = "allow"
+	PodWebhookDecisionDeny  = "deny"
+
+	PodWebhookReasonNoClaims         = "NoClaims"
+	PodWebhookReasonClaimed          = "Claimed"
+	PodWebhookReasonInvalid          = "Invalid"
+	PodWebhookReasonClaimUnavailable = "ClaimUnavailable"
+)
+
+var (
+	// AllocationDuration observes the time from the creation of a HostPort until it is allocated a port
+	AllocationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "allocation_duration_seconds",
+		Help:      "Time from the creation of a HostPort until it is allocated a port.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, []string{"class"})
+
+	// AllocationFailures counts the failed attempts to allocate a port to a HostPort
+	AllocationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "allocation_failures_total",
+		Help:      "Failed attempts to allocate a port to a HostPort by reason.",
+	}, []string{"class", "reason"})
+
+	// PodWebhookDecisions counts the pods allowed and denied by the pod webhook
+	PodWebhookDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pod_webhook_decisions_total",
+		Help:      "Pods allowed and denied by the pod webhook when created by reason.",
+	}, []string{"decision", "reason"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(AllocationDuration, AllocationFailures, PodWebhookDecisions)
+}
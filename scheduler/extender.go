@@ -0,0 +1,367 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+// Extender is a kube-scheduler extender that filters out nodes where the host ports allocated to a pod are taken
+// and prefers nodes with more free ports in the HostPortClasses of the pod's claims
+type Extender struct {
+	client.Client
+	Log logr.Logger
+
+	// The address the extender binds to
+	BindAddress string
+}
+
+// +kubebuilder:rbac:groups="",resources=nodes,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=hostport.rmb938.com,resources=hostportnodes,verbs=get;list;watch
+
+func (e *Extender) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(e)
+}
+
+// NeedLeaderElection returns false so every replica serves the scheduler
+func (e *Extender) NeedLeaderElection() bool {
+	return false
+}
+
+func (e *Extender) Start(ctx context.Context) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/filter", e.handleFilter)
+	mux.HandleFunc("/prioritize", e.handlePrioritize)
+
+	server := &http.Server{
+		Addr:              e.BindAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		e.Log.Info("starting scheduler extender", "address", e.BindAddress)
+		errCh <- server.ListenAndServe()
+	}()
+
+	select {
+	case <-ctx.Done():
+		return server.Shutdown(context.Background())
+	case err := <-errCh:
+		return err
+	}
+}
+
+func (e *Extender) handleFilter(w http.ResponseWriter, req *http.Request) {
+	args := &ExtenderArgs{}
+	err := json.NewDecoder(req.Body).Decode(args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	e.writeResponse(w, e.Filter(req.Context(), args))
+}
+
+func (e *Extender) handlePrioritize(w http.ResponseWriter, req *http.Request) {
+	args := &ExtenderArgs{}
+	err := json.NewDecoder(req.Body).Decode(args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	priorities, err := e.Prioritize(req.Context(), args)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	e.writeResponse(w, priorities)
+}
+
+func (e *Extender) writeResponse(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		e.Log.Error(err, "error writing scheduler extender response")
+	}
+}
+
+// Filter removes the nodes where a host port allocated to the pod is already taken
+func (e *Extender) Filter(ctx context.Context, args *ExtenderArgs) *ExtenderFilterResult {
+	result := &ExtenderFilterResult{
+		Nodes:       args.Nodes,
+		NodeNames:   args.NodeNames,
+		FailedNodes: FailedNodesMap{},
+	}
+
+	if args.Pod == nil {
+		return result
+	}
+
+	ports := podAllocatedPorts(args.Pod)
+	if len(ports) == 0 {
+		return result
+	}
+
+	nodes, err := e.candidateNodes(ctx, args)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	takenPorts, err := e.takenPorts(ctx, nodes, args.Pod)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	var passedNodes []corev1.Node
+	var passedNodeNames []string
+	for _, node := range nodes {
+		failed := false
+		for _, port := range ports {
+			if _, ok := takenPorts[node.Name][port]; ok {
+				result.FailedNodes[node.Name] = fmt.Sprintf("host port %d is taken", port)
+				failed = true
+				break
+			}
+		}
+
+		if failed == false {
+			passedNodes = append(passedNodes, node)
+			passedNodeNames = append(passedNodeNames, node.Name)
+		}
+	}
+
+	if args.NodeNames != nil {
+		result.NodeNames = &passedNodeNames
+	} else {
+		result.Nodes = &corev1.NodeList{Items: passedNodes}
+	}
+
+	return result
+}
+
+// Prioritize scores nodes by the fraction of free ports in the HostPortClasses of the pod's claims
+func (e *Extender) Prioritize(ctx context.Context, args *ExtenderArgs) (HostPriorityList, error) {
+	nodes, err := e.candidateNodes(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+
+	priorities := make(HostPriorityList, 0, len(nodes))
+	for _, node := range nodes {
+		priorities = append(priorities, HostPriority{Host: node.Name})
+	}
+
+	if args.Pod == nil {
+		return priorities, nil
+	}
+
+	classes, err := e.podClasses(ctx, args.Pod)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(classes) == 0 {
+		return priorities, nil
+	}
+
+	takenPorts, err := e.takenPorts(ctx, nodes, args.Pod)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, node := range nodes {
+		var score int64
+		for _, hpcl := range classes {
+			capacity := 0
+			for _, pool := range hpcl.Spec.Pools {
+				capacity += pool.End - pool.Start + 1
+			}
+
+			free := capacity
+			for port := range takenPorts[node.Name] {
+				for _, pool := range hpcl.Spec.Pools {
+					if port >= pool.Start && port <= pool.End {
+						free--
+						break
+					}
+				}
+			}
+
+			if capacity > 0 {
+				score += int64(free) * MaxExtenderPriority / int64(capacity)
+			}
+		}
+
+		priorities[i].Score = score / int64(len(classes))
+	}
+
+	return priorities, nil
+}
+
+// candidateNodes returns the nodes sent by the scheduler, looking them up when only names were sent
+func (e *Extender) candidateNodes(ctx context.Context, args *ExtenderArgs) ([]corev1.Node, error) {
+	if args.Nodes != nil {
+		return args.Nodes.Items, nil
+	}
+
+	if args.NodeNames == nil {
+		return nil, nil
+	}
+
+	nodes := make([]corev1.Node, 0, len(*args.NodeNames))
+	for _, nodeName := range *args.NodeNames {
+		node := &corev1.Node{}
+		err := e.Get(ctx, types.NamespacedName{Name: nodeName}, node)
+		if err != nil {
+			if apierrors.IsNotFound(err) == false {
+				return nil, err
+			}
+			node = &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: nodeName}}
+		}
+		nodes = append(nodes, *node)
+	}
+
+	return nodes, nil
+}
+
+// takenPorts returns the host ports used by other pods and the ports reported as taken, grouped by the node name
+func (e *Extender) takenPorts(ctx context.Context, nodes []corev1.Node, pod *corev1.Pod) (map[string]map[int]struct{}, error) {
+	takenPorts := make(map[string]map[int]struct{}, len(nodes))
+	for _, node := range nodes {
+		takenPorts[node.Name] = make(map[int]struct{})
+
+		for _, value := range strings.Split(node.Annotations[hostportv1alpha1.HostPortNodeAnnotationTakenPorts], ",") {
+			port, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				continue
+			}
+			takenPorts[node.Name][port] = struct{}{}
+		}
+	}
+
+	// pods are listed once for all the nodes instead of once per node
+	podList := &corev1.PodList{}
+	err := e.List(ctx, podList)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, nodePod := range podList.Items {
+		nodePorts, ok := takenPorts[nodePod.Spec.NodeName]
+		if !ok || nodePod.UID == pod.UID {
+			continue
+		}
+
+		if nodePod.Status.Phase == corev1.PodSucceeded || nodePod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+
+		for _, containers := range [][]corev1.Container{nodePod.Spec.Containers, nodePod.Spec.InitContainers} {
+			for _, container := range containers {
+				for _, port := range container.Ports {
+					if port.HostPort > 0 {
+						nodePorts[int(port.HostPort)] = struct{}{}
+					}
+				}
+			}
+		}
+	}
+
+	// ports the node agent found bound by something other than their pods
+	hostPortNodeList := &hostportv1alpha1.HostPortNodeList{}
+	err = e.List(ctx, hostPortNodeList)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, hpn := range hostPortNodeList.Items {
+		nodePorts, ok := takenPorts[hpn.Name]
+		if !ok {
+			continue
+		}
+
+		for _, conflict := range hpn.Status.Conflicts {
+			nodePorts[conflict.Port] = struct{}{}
+		}
+	}
+
+	return takenPorts, nil
+}
+
+// podClasses returns the HostPortClasses of the claims the pod references
+func (e *Extender) podClasses(ctx context.Context, pod *corev1.Pod) ([]*hostportv1alpha1.HostPortClass, error) {
+	classNames := make(map[string]struct{})
+	for annotation, claimName := range pod.Annotations {
+		if strings.HasPrefix(annotation, hostportv1alpha1.HostPortPodAnnotationClaimPrefix+"/") == false {
+			continue
+		}
+
+		hpc := &hostportv1alpha1.HostPortClaim{}
+		err := e.Get(ctx, types.NamespacedName{Namespace: pod.Namespace, Name: claimName}, hpc)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		classNames[hpc.BoundHostPortClassName()] = struct{}{}
+	}
+
+	var classes []*hostportv1alpha1.HostPortClass
+	for className := range classNames {
+		hpcl := &hostportv1alpha1.HostPortClass{}
+		err := e.Get(ctx, types.NamespacedName{Name: className}, hpcl)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+
+		classes = append(classes, hpcl)
+	}
+
+	return classes, nil
+}
+
+// podAllocatedPorts returns the host ports allocated to the pod from its port annotations
+func podAllocatedPorts(pod *corev1.Pod) []int {
+	var ports []int
+	for annotation, value := range pod.Annotations {
+		if strings.HasPrefix(annotation, hostportv1alpha1.HostPortPodAnnotationPortPrefix+"/") == false {
+			continue
+		}
+
+		port, err := strconv.Atoi(value)
+		if err != nil {
+			continue
+		}
+		ports = append(ports, port)
+	}
+	sort.Ints(ports)
+
+	return ports
+}
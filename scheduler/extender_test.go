@@ -0,0 +1,204 @@
+package scheduler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	hostportv1alpha1 "github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+func newFakeClient(objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = hostportv1alpha1.AddToScheme(scheme)
+
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).WithStatusSubresource(&hostportv1alpha1.HostPortNode{}).Build()
+}
+
+func node(name string, takenPorts string) corev1.Node {
+	n := corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if len(takenPorts) > 0 {
+		n.Annotations = map[string]string{hostportv1alpha1.HostPortNodeAnnotationTakenPorts: takenPorts}
+	}
+	return n
+}
+
+func nodePod(name string, nodeName string, hostPort int32) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, UID: types.UID("uid-" + name)},
+		Spec: corev1.PodSpec{
+			NodeName:   nodeName,
+			Containers: []corev1.Container{{Name: "app", Ports: []corev1.ContainerPort{{ContainerPort: 80, HostPort: hostPort}}}},
+		},
+	}
+}
+
+func claimPod(port string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: "default",
+			Name:      "pod",
+			UID:       "uid-pod",
+			Annotations: map[string]string{
+				hostportv1alpha1.HostPortPodAnnotationClaimPrefix + "/game": "claim",
+				hostportv1alpha1.HostPortPodAnnotationPortPrefix + "/game":  port,
+			},
+		},
+	}
+}
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		objects  []client.Object
+		nodes    []corev1.Node
+		expected []string
+	}{
+		{
+			name:     "free",
+			pod:      claimPod("9000"),
+			nodes:    []corev1.Node{node("a", ""), node("b", "")},
+			expected: []string{"a", "b"},
+		},
+		{
+			name:     "taken by a pod",
+			pod:      claimPod("9000"),
+			objects:  []client.Object{nodePod("other", "a", 9000), nodePod("elsewhere", "c", 9000)},
+			nodes:    []corev1.Node{node("a", ""), node("b", "")},
+			expected: []string{"b"},
+		},
+		{
+			name:     "pod itself",
+			pod:      claimPod("9000"),
+			objects:  []client.Object{nodePod("pod", "a", 9000)},
+			nodes:    []corev1.Node{node("a", ""), node("b", "")},
+			expected: []string{"a", "b"},
+		},
+		{
+			name: "HostPortNode conflict",
+			pod:  claimPod("9000"),
+			objects: []client.Object{&hostportv1alpha1.HostPortNode{
+				ObjectMeta: metav1.ObjectMeta{Name: "b"},
+				Status: hostportv1alpha1.HostPortNodeStatus{
+					Conflicts: []hostportv1alpha1.HostPortNodeConflict{{Port: 9000, Protocol: corev1.ProtocolTCP}},
+				},
+			}},
+			nodes:    []corev1.Node{node("a", ""), node("b", "")},
+			expected: []string{"a"},
+		},
+		{
+			name:     "node annotation",
+			pod:      claimPod("9000"),
+			nodes:    []corev1.Node{node("a", "8080, 9000"), node("b", "8080")},
+			expected: []string{"b"},
+		},
+		{
+			name:     "no claims",
+			pod:      nodePod("pod", "", 0),
+			objects:  []client.Object{nodePod("other", "a", 9000)},
+			nodes:    []corev1.Node{node("a", "9000"), node("b", "")},
+			expected: []string{"a", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &Extender{Client: newFakeClient(test.objects...)}
+
+			result := e.Filter(context.Background(), &ExtenderArgs{Pod: test.pod, Nodes: &corev1.NodeList{Items: test.nodes}})
+			if len(result.Error) > 0 {
+				t.Fatal(result.Error)
+			}
+
+			var names []string
+			for _, n := range result.Nodes.Items {
+				names = append(names, n.Name)
+			}
+
+			if reflect.DeepEqual(names, test.expected) == false {
+				t.Errorf("unexpected nodes %v", names)
+			}
+
+			if len(result.FailedNodes) != len(test.nodes)-len(test.expected) {
+				t.Errorf("unexpected failed nodes %v", result.FailedNodes)
+			}
+		})
+	}
+}
+
+func TestPrioritize(t *testing.T) {
+	hpc := &hostportv1alpha1.HostPortClaim{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim"},
+		Spec:       hostportv1alpha1.HostPortClaimSpec{HostPortClassName: "class"},
+	}
+
+	hpcl := &hostportv1alpha1.HostPortClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "class"},
+		Spec: hostportv1alpha1.HostPortClassSpec{
+			Pools: []hostportv1alpha1.HostPortClassSpecPool{{Start: 9000, End: 9004}, {Start: 9010, End: 9014}},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		objects  []client.Object
+		nodes    []corev1.Node
+		expected HostPriorityList
+	}{
+		{
+			name:     "all free",
+			pod:      claimPod("9000"),
+			objects:  []client.Object{hpc, hpcl},
+			nodes:    []corev1.Node{node("a", ""), node("b", "")},
+			expected: HostPriorityList{{Host: "a", Score: 10}, {Host: "b", Score: 10}},
+		},
+		{
+			name:    "taken ports",
+			pod:     claimPod("9000"),
+			objects: []client.Object{hpc, hpcl, nodePod("other", "b", 9001), nodePod("outside", "b", 8080)},
+			nodes:   []corev1.Node{node("a", "9010,9011,9012,9013,9014"), node("b", "9002,9003")},
+			// taken ports outside of the pools don't count
+			expected: HostPriorityList{{Host: "a", Score: 5}, {Host: "b", Score: 7}},
+		},
+		{
+			name:     "no claims",
+			pod:      nodePod("pod", "", 0),
+			objects:  []client.Object{hpc, hpcl},
+			nodes:    []corev1.Node{node("a", "9000"), node("b", "")},
+			expected: HostPriorityList{{Host: "a"}, {Host: "b"}},
+		},
+		{
+			name:     "missing claim",
+			pod:      claimPod("9000"),
+			objects:  []client.Object{hpcl},
+			nodes:    []corev1.Node{node("a", "9000"), node("b", "")},
+			expected: HostPriorityList{{Host: "a"}, {Host: "b"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			e := &Extender{Client: newFakeClient(test.objects...)}
+
+			priorities, err := e.Prioritize(context.Background(), &ExtenderArgs{Pod: test.pod, Nodes: &corev1.NodeList{Items: test.nodes}})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if reflect.DeepEqual(priorities, test.expected) == false {
+				t.Errorf("unexpected priorities %v", priorities)
+			}
+		})
+	}
+}
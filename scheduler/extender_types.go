@@ -0,0 +1,53 @@
+package scheduler
+
+import (
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The types below mirror k8s.io/kube-scheduler/extender/v1 which is the wire format
+// the kube-scheduler uses to call extenders
+
+// MaxExtenderPriority is the highest score an extender can give a node
+const MaxExtenderPriority int64 = 10
+
+// ExtenderArgs is sent by the scheduler to the filter and prioritize verbs
+type ExtenderArgs struct {
+	// The pod being scheduled
+	Pod *corev1.Pod
+
+	// The candidate nodes, set when the extender is not node cache capable
+	Nodes *corev1.NodeList
+
+	// The candidate node names, set when the extender is node cache capable
+	NodeNames *[]string
+}
+
+// FailedNodesMap maps the names of filtered out nodes to the reason they failed
+type FailedNodesMap map[string]string
+
+// ExtenderFilterResult is returned by the filter verb
+type ExtenderFilterResult struct {
+	// The nodes that passed the filter, set when the extender is not node cache capable
+	Nodes *corev1.NodeList
+
+	// The node names that passed the filter, set when the extender is node cache capable
+	NodeNames *[]string
+
+	// The nodes that failed the filter
+	FailedNodes FailedNodesMap
+
+	// The nodes that failed the filter and can't be fixed by preemption
+	FailedAndUnresolvableNodes FailedNodesMap
+
+	// An error that prevented filtering
+	Error string
+}
+
+// HostPriority is the score of a single node
+type HostPriority struct {
+	Host  string
+	Score int64
+}
+
+// HostPriorityList is returned by the prioritize verb
+type HostPriorityList []HostPriority
@@ -21,11 +21,18 @@ import (
 	"fmt"
 
 	"github.com/rmb938/hostport-allocator/api/v1alpha1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
@@ -38,7 +45,7 @@ var hostportclaimlog = logf.Log.WithName("hostportclaim-resource")
 func SetupHostPortClaimWebhookWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(&v1alpha1.HostPortClaim{}).
-		WithValidator(&HostPortClaimValidator{}).
+		WithValidator(&HostPortClaimValidator{client: mgr.GetClient()}).
 		WithDefaulter(&HostPortClaimDefaulter{}).
 		Complete()
 }
@@ -70,10 +77,190 @@ func (d *HostPortClaimDefaulter) Default(ctx context.Context, obj runtime.Object
 // TODO(user): change verbs to "verbs=create;update;delete" if you want to enable deletion validation.
 // +kubebuilder:webhook:verbs=create;update,path=/validate-hostport-rmb938-com-v1alpha1-hostportclaim,mutating=false,failurePolicy=fail,groups=hostport.rmb938.com,resources=hostportclaims,versions=v1alpha1,sideEffects=None,admissionReviewVersions=v1,name=vhostportclaim.kb.io
 
-type HostPortClaimValidator struct{}
+// +kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+type HostPortClaimValidator struct {
+	client client.Client
+}
 
 var _ webhook.CustomValidator = &HostPortClaimValidator{}
 
+func (d *HostPortClaimValidator) validateSpec(r *v1alpha1.HostPortClaim) field.ErrorList {
+	var allErrs field.ErrorList
+
+	hostPortClassNames := map[string]struct{}{r.Spec.HostPortClassName: {}}
+	for i, hostPortClassName := range r.Spec.FallbackHostPortClassNames {
+		path := field.NewPath("spec").Child("fallbackHostPortClassNames").Index(i)
+		if _, ok := hostPortClassNames[hostPortClassName]; ok {
+			allErrs = append(allErrs, field.Duplicate(path, hostPortClassName))
+			continue
+		}
+		hostPortClassNames[hostPortClassName] = struct{}{}
+	}
+
+	if r.Spec.ReleaseAfterUnused != nil && r.Spec.ReleaseAfterUnused.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("releaseAfterUnused"), r.Spec.ReleaseAfterUnused.Duration.String(),
+			"releaseAfterUnused must be greater than zero"))
+	}
+
+	return allErrs
+}
+
+func (d *HostPortClaimValidator) validateClasses(ctx context.Context, r *v1alpha1.HostPortClaim) field.ErrorList {
+	var allErrs field.ErrorList
+
+	allErrs = append(allErrs, d.validateClass(ctx, r, field.NewPath("spec").Child("hostPortClassName"), r.Spec.HostPortClassName)...)
+
+	for i, hostPortClassName := range r.Spec.FallbackHostPortClassNames {
+		allErrs = append(allErrs, d.validateClass(ctx, r, field.NewPath("spec").Child("fallbackHostPortClassNames").Index(i), hostPortClassName)...)
+	}
+
+	return allErrs
+}
+
+func (d *HostPortClaimValidator) validateClass(ctx context.Context, r *v1alpha1.HostPortClaim, path *field.Path, hostPortClassName string) field.ErrorList {
+	var allErrs field.ErrorList
+
+	hpcl := &v1alpha1.HostPortClass{}
+	err := d.client.Get(ctx, types.NamespacedName{Name: hostPortClassName}, hpcl)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return append(allErrs, field.NotFound(path, hostPortClassName))
+		}
+		return append(allErrs, field.InternalError(path, err))
+	}
+
+	if hpcl.DeletionTimestamp.IsZero() == false {
+		return append(allErrs, field.Invalid(path, hostPortClassName,
+			"hostPortClass is deleting"))
+	}
+
+	if r.Spec.Priority > hpcl.Spec.MaxPriority {
+		allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("priority"),
+			fmt.Sprintf("priority may not be higher than %d in hostPortClass %s", hpcl.Spec.MaxPriority, hpcl.Name)))
+	}
+
+	return append(allErrs, d.validateClassAccess(ctx, r, path, hpcl)...)
+}
+
+func (d *HostPortClaimValidator) validateHostPort(ctx context.Context, r *v1alpha1.HostPortClaim) field.ErrorList {
+	var allErrs field.ErrorList
+	path := field.NewPath("spec").Child("hostPortName")
+
+	if len(r.Spec.HostPortName) == 0 {
+		return allErrs
+	}
+
+	hp := &v1alpha1.HostPort{}
+	err := d.client.Get(ctx, types.NamespacedName{Name: r.Spec.HostPortName}, hp)
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return append(allErrs, field.NotFound(path, r.Spec.HostPortName))
+		}
+		return append(allErrs, field.InternalError(path, err))
+	}
+
+	if hp.DeletionTimestamp.IsZero() == false {
+		allErrs = append(allErrs, field.Invalid(path, r.Spec.HostPortName,
+			"hostPort is deleting"))
+	}
+
+	if hp.Spec.ClaimRef != nil && hp.Spec.ClaimRef.UID != r.UID {
+		allErrs = append(allErrs, field.Invalid(path, r.Spec.HostPortName,
+			fmt.Sprintf("hostPort is already bound to claim %s/%s", hp.Spec.ClaimRef.Namespace, hp.Spec.ClaimRef.Name)))
+	}
+
+	classAllowed := false
+	for _, hostPortClassName := range r.HostPortClassNames() {
+		if hp.Spec.HostPortClassName == hostPortClassName {
+			classAllowed = true
+			break
+		}
+	}
+
+	if classAllowed == false {
+		allErrs = append(allErrs, field.Invalid(path, r.Spec.HostPortName,
+			fmt.Sprintf("hostPort belongs to hostPortClass %s", hp.Spec.HostPortClassName)))
+	}
+
+	return allErrs
+}
+
+func (d *HostPortClaimValidator) validateClassAccess(ctx context.Context, r *v1alpha1.HostPortClaim, path *field.Path, hpcl *v1alpha1.HostPortClass) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if len(hpcl.Spec.AllowedNamespaces) > 0 || hpcl.Spec.NamespaceSelector != nil {
+		allowed := false
+
+		for _, namespace := range hpcl.Spec.AllowedNamespaces {
+			if namespace == r.Namespace {
+				allowed = true
+				break
+			}
+		}
+
+		if allowed == false && hpcl.Spec.NamespaceSelector != nil {
+			selector, err := metav1.LabelSelectorAsSelector(hpcl.Spec.NamespaceSelector)
+			if err != nil {
+				return append(allErrs, field.InternalError(path, err))
+			}
+
+			ns := &corev1.Namespace{}
+			err = d.client.Get(ctx, types.NamespacedName{Name: r.Namespace}, ns)
+			if err != nil {
+				return append(allErrs, field.InternalError(path, err))
+			}
+
+			allowed = selector.Matches(labels.Set(ns.Labels))
+		}
+
+		if allowed == false {
+			allErrs = append(allErrs, field.Forbidden(path,
+				fmt.Sprintf("namespace %s is not allowed to use hostPortClass %s", r.Namespace, hpcl.Name)))
+		}
+	}
+
+	if hpcl.Spec.RequireUsePermission {
+		req, err := admission.RequestFromContext(ctx)
+		if err != nil {
+			return append(allErrs, field.InternalError(path, err))
+		}
+
+		extra := make(map[string]authorizationv1.ExtraValue, len(req.UserInfo.Extra))
+		for key, value := range req.UserInfo.Extra {
+			extra[key] = authorizationv1.ExtraValue(value)
+		}
+
+		sar := &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				User:   req.UserInfo.Username,
+				Groups: req.UserInfo.Groups,
+				UID:    req.UserInfo.UID,
+				Extra:  extra,
+				ResourceAttributes: &authorizationv1.ResourceAttributes{
+					Group:    v1alpha1.GroupVersion.Group,
+					Version:  v1alpha1.GroupVersion.Version,
+					Resource: "hostportclasses",
+					Verb:     "use",
+					Name:     hpcl.Name,
+				},
+			},
+		}
+		err = d.client.Create(ctx, sar)
+		if err != nil {
+			return append(allErrs, field.InternalError(path, err))
+		}
+
+		if sar.Status.Allowed == false {
+			allErrs = append(allErrs, field.Forbidden(path,
+				fmt.Sprintf("user %s is not allowed to use hostPortClass %s", req.UserInfo.Username, hpcl.Name)))
+		}
+	}
+
+	return allErrs
+}
+
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
 func (d *HostPortClaimValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
 	r, ok := obj.(*v1alpha1.HostPortClaim)
@@ -85,6 +272,10 @@ func (d *HostPortClaimValidator) ValidateCreate(ctx context.Context, obj runtime
 
 	var allErrs field.ErrorList
 
+	allErrs = append(allErrs, d.validateSpec(r)...)
+	allErrs = append(allErrs, d.validateClasses(ctx, r)...)
+	allErrs = append(allErrs, d.validateHostPort(ctx, r)...)
+
 	if len(allErrs) == 0 {
 		return nil, nil
 	}
@@ -107,7 +298,7 @@ func (d *HostPortClaimValidator) ValidateUpdate(ctx context.Context, old runtime
 		return nil, fmt.Errorf("expected a HostPortClaim old object but got %T", old)
 	}
 
-	var allErrs field.ErrorList
+	allErrs := d.validateSpec(r)
 
 	if r.Spec.HostPortClassName != oldHPC.Spec.HostPortClassName {
 		allErrs = append(allErrs,
@@ -116,6 +307,13 @@ func (d *HostPortClaimValidator) ValidateUpdate(ctx context.Context, old runtime
 		)
 	}
 
+	if equality.Semantic.DeepEqual(r.Spec.FallbackHostPortClassNames, oldHPC.Spec.FallbackHostPortClassNames) == false {
+		allErrs = append(allErrs,
+			field.Forbidden(field.NewPath("spec").Child("fallbackHostPortClassNames"),
+				"cannot change fallbackHostPortClassNames"),
+		)
+	}
+
 	if len(oldHPC.Spec.HostPortName) > 0 && oldHPC.Spec.HostPortName != r.Spec.HostPortName {
 		allErrs = append(allErrs,
 			field.Forbidden(field.NewPath("spec").Child("hostPortName"),
@@ -123,6 +321,11 @@ func (d *HostPortClaimValidator) ValidateUpdate(ctx context.Context, old runtime
 		)
 	}
 
+	// a raised priority is checked against the classes again
+	if r.Spec.Priority > oldHPC.Spec.Priority {
+		allErrs = append(allErrs, d.validateClasses(ctx, r)...)
+	}
+
 	if len(allErrs) == 0 {
 		return nil, nil
 	}
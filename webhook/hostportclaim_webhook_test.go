@@ -0,0 +1,289 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+func newFakeClient(objects ...client.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = v1alpha1.AddToScheme(scheme)
+
+	return fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build()
+}
+
+func hostPortClass(name string, maxPriority int) *v1alpha1.HostPortClass {
+	return &v1alpha1.HostPortClass{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec: v1alpha1.HostPortClassSpec{
+			Pools:       []v1alpha1.HostPortClassSpecPool{{Start: 9000, End: 9010}},
+			MaxPriority: maxPriority,
+		},
+	}
+}
+
+func TestHostPortClaimValidatePriority(t *testing.T) {
+	tests := []struct {
+		name        string
+		priority    int
+		oldPriority int
+		fallback    bool
+		valid       bool
+	}{
+		{name: "default priority", valid: true},
+		{name: "lower priority", priority: -10, valid: true},
+		{name: "max priority", priority: 10, valid: true},
+		{name: "above max priority", priority: 11, valid: false},
+		{name: "above fallback max priority", priority: 5, fallback: true, valid: false},
+		{name: "lowered priority", priority: 20, oldPriority: 30, valid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &HostPortClaimValidator{client: newFakeClient(hostPortClass("class", 10), hostPortClass("fallback", 0))}
+
+			hpc := &v1alpha1.HostPortClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim"},
+				Spec:       v1alpha1.HostPortClaimSpec{HostPortClassName: "class", Priority: test.priority},
+			}
+			if test.fallback {
+				hpc.Spec.FallbackHostPortClassNames = []string{"fallback"}
+			}
+
+			var err error
+			if test.oldPriority != 0 {
+				old := hpc.DeepCopy()
+				old.Spec.Priority = test.oldPriority
+				_, err = d.ValidateUpdate(context.Background(), old, hpc)
+			} else {
+				_, err = d.ValidateCreate(context.Background(), hpc)
+			}
+
+			if (err == nil) != test.valid {
+				t.Errorf("expected valid to be %v but got %v", test.valid, err)
+			}
+
+			// raising the priority on update is checked the same as on create
+			if test.oldPriority == 0 {
+				old := hpc.DeepCopy()
+				old.Spec.Priority = -1000
+				_, err = d.ValidateUpdate(context.Background(), old, hpc)
+				if (err == nil) != test.valid {
+					t.Errorf("expected valid update to be %v but got %v", test.valid, err)
+				}
+			}
+		})
+	}
+}
+
+func TestHostPortClaimValidateClassAccess(t *testing.T) {
+	namespaces := []client.Object{
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "games", Labels: map[string]string{"team": "games"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other"}},
+	}
+
+	// only the admin user is allowed to use classes requiring the use permission
+	interceptors := interceptor.Funcs{
+		Create: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.CreateOption) error {
+			if sar, ok := obj.(*authorizationv1.SubjectAccessReview); ok {
+				attributes := sar.Spec.ResourceAttributes
+				sar.Status.Allowed = sar.Spec.User == "admin" && attributes.Verb == "use" && attributes.Resource == "hostportclasses"
+				return nil
+			}
+			return c.Create(ctx, obj, opts...)
+		},
+	}
+
+	tests := []struct {
+		name      string
+		spec      v1alpha1.HostPortClassSpec
+		namespace string
+		user      string
+		valid     bool
+	}{
+		{
+			name:      "unrestricted",
+			namespace: "other",
+			valid:     true,
+		},
+		{
+			name:      "allowed namespace",
+			spec:      v1alpha1.HostPortClassSpec{AllowedNamespaces: []string{"games"}},
+			namespace: "games",
+			valid:     true,
+		},
+		{
+			name:      "namespace not allowed",
+			spec:      v1alpha1.HostPortClassSpec{AllowedNamespaces: []string{"games"}},
+			namespace: "other",
+			valid:     false,
+		},
+		{
+			name:      "namespace selector",
+			spec:      v1alpha1.HostPortClassSpec{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "games"}}},
+			namespace: "games",
+			valid:     true,
+		},
+		{
+			name:      "namespace selector not matched",
+			spec:      v1alpha1.HostPortClassSpec{NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "games"}}},
+			namespace: "other",
+			valid:     false,
+		},
+		{
+			name: "allowed namespace or namespace selector",
+			spec: v1alpha1.HostPortClassSpec{
+				AllowedNamespaces: []string{"other"},
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "games"}},
+			},
+			namespace: "other",
+			valid:     true,
+		},
+		{
+			name:      "use permission",
+			spec:      v1alpha1.HostPortClassSpec{RequireUsePermission: true},
+			namespace: "other",
+			user:      "admin",
+			valid:     true,
+		},
+		{
+			name:      "use permission denied",
+			spec:      v1alpha1.HostPortClassSpec{RequireUsePermission: true},
+			namespace: "other",
+			user:      "tenant",
+			valid:     false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scheme := runtime.NewScheme()
+			_ = clientgoscheme.AddToScheme(scheme)
+			_ = v1alpha1.AddToScheme(scheme)
+
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(namespaces...).WithInterceptorFuncs(interceptors).Build()
+			d := &HostPortClaimValidator{client: c}
+
+			hpcl := &v1alpha1.HostPortClass{ObjectMeta: metav1.ObjectMeta{Name: "class"}, Spec: test.spec}
+			hpc := &v1alpha1.HostPortClaim{ObjectMeta: metav1.ObjectMeta{Namespace: test.namespace, Name: "claim"}}
+
+			ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				UserInfo: authenticationv1.UserInfo{Username: test.user},
+			}})
+
+			allErrs := d.validateClassAccess(ctx, hpc, field.NewPath("spec").Child("hostPortClassName"), hpcl)
+			if (len(allErrs) == 0) != test.valid {
+				t.Errorf("expected valid to be %v but got %v", test.valid, allErrs)
+			}
+		})
+	}
+}
+
+func TestHostPortClaimValidateClass(t *testing.T) {
+	deleting := hostPortClass("deleting", 0)
+	deleting.Finalizers = []string{"test"}
+	deleting.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	tests := []struct {
+		name      string
+		className string
+		errType   field.ErrorType
+	}{
+		{name: "class", className: "class"},
+		{name: "missing class", className: "missing", errType: field.ErrorTypeNotFound},
+		{name: "deleting class", className: "deleting", errType: field.ErrorTypeInvalid},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &HostPortClaimValidator{client: newFakeClient(hostPortClass("class", 0), deleting)}
+
+			hpc := &v1alpha1.HostPortClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim"},
+				Spec:       v1alpha1.HostPortClaimSpec{HostPortClassName: test.className},
+			}
+
+			allErrs := d.validateClass(context.Background(), hpc, field.NewPath("spec").Child("hostPortClassName"), test.className)
+			if len(test.errType) == 0 {
+				if len(allErrs) != 0 {
+					t.Errorf("expected no errors but got %v", allErrs)
+				}
+				return
+			}
+
+			if len(allErrs) != 1 || allErrs[0].Type != test.errType {
+				t.Errorf("expected a single %s error but got %v", test.errType, allErrs)
+			}
+		})
+	}
+}
+
+func TestHostPortClaimValidateHostPort(t *testing.T) {
+	hostPort := func(name string, className string, claimUID types.UID) *v1alpha1.HostPort {
+		hp := &v1alpha1.HostPort{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec:       v1alpha1.HostPortSpec{HostPortClassName: className},
+		}
+		if len(claimUID) > 0 {
+			hp.Spec.ClaimRef = &corev1.ObjectReference{Namespace: "default", Name: "claim-" + string(claimUID), UID: claimUID}
+		}
+		return hp
+	}
+
+	tests := []struct {
+		name         string
+		hostPortName string
+		fallback     bool
+		valid        bool
+	}{
+		{name: "no hostPort", valid: true},
+		{name: "unbound hostPort", hostPortName: "unbound", valid: true},
+		{name: "hostPort bound to claim", hostPortName: "bound", valid: true},
+		{name: "hostPort bound to another claim", hostPortName: "taken", valid: false},
+		{name: "missing hostPort", hostPortName: "missing", valid: false},
+		{name: "hostPort class mismatch", hostPortName: "fallback", valid: false},
+		{name: "hostPort in fallback class", hostPortName: "fallback", fallback: true, valid: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &HostPortClaimValidator{client: newFakeClient(
+				hostPort("unbound", "class", ""),
+				hostPort("bound", "class", "claim"),
+				hostPort("taken", "class", "other"),
+				hostPort("fallback", "fallback", ""),
+			)}
+
+			hpc := &v1alpha1.HostPortClaim{
+				ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "claim", UID: "claim"},
+				Spec:       v1alpha1.HostPortClaimSpec{HostPortClassName: "class", HostPortName: test.hostPortName},
+			}
+			if test.fallback {
+				hpc.Spec.FallbackHostPortClassNames = []string{"fallback"}
+			}
+
+			allErrs := d.validateHostPort(context.Background(), hpc)
+			if (len(allErrs) == 0) != test.valid {
+				t.Errorf("expected valid to be %v but got %v", test.valid, allErrs)
+			}
+		})
+	}
+}
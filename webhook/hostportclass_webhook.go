@@ -22,6 +22,7 @@ import (
 
 	"github.com/rmb938/hostport-allocator/api/v1alpha1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/validation/field"
@@ -82,6 +83,22 @@ func (d *HostPortClassValidator) validatePools(r *v1alpha1.HostPortClass) field.
 			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("pools").Index(index).Child("end"), pool.End,
 				"End must be greater or equal to start"))
 		}
+
+		if pool.NamespaceSelector != nil {
+			_, err := metav1.LabelSelectorAsSelector(pool.NamespaceSelector)
+			if err != nil {
+				allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("pools").Index(index).Child("namespaceSelector"), pool.NamespaceSelector,
+					err.Error()))
+			}
+		}
+	}
+
+	if r.Spec.NamespaceSelector != nil {
+		_, err := metav1.LabelSelectorAsSelector(r.Spec.NamespaceSelector)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("namespaceSelector"), r.Spec.NamespaceSelector,
+				err.Error()))
+		}
 	}
 
 	// TODO: make sure there are no overlapping pools
@@ -0,0 +1,154 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhook
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/rmb938/hostport-allocator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var hostportexemptionlog = logf.Log.WithName("hostportexemption-resource")
+
+func SetupHostPortExemptionWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&v1alpha1.HostPortExemption{}).
+		WithValidator(&HostPortExemptionValidator{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-hostport-rmb938-com-v1alpha1-hostportexemption,mutating=false,failurePolicy=fail,groups=hostport.rmb938.com,resources=hostportexemptions,versions=v1alpha1,sideEffects=None,admissionReviewVersions=v1,name=vhostportexemption.kb.io
+
+type HostPortExemptionValidator struct{}
+
+var _ webhook.CustomValidator = &HostPortExemptionValidator{}
+
+func (d *HostPortExemptionValidator) validateSpec(r *v1alpha1.HostPortExemption) (admission.Warnings, field.ErrorList) {
+	var warnings admission.Warnings
+	var allErrs field.ErrorList
+
+	if len(r.Spec.Namespaces) == 0 && len(r.Spec.ServiceAccounts) == 0 && r.Spec.PodSelector == nil {
+		warnings = append(warnings, "exemption has no namespaces, serviceAccounts or podSelector so it exempts no pods")
+	}
+
+	for index, serviceAccount := range r.Spec.ServiceAccounts {
+		path := field.NewPath("spec").Child("serviceAccounts").Index(index)
+		if len(serviceAccount.Namespace) == 0 {
+			allErrs = append(allErrs, field.Required(path.Child("namespace"), "namespace must be set"))
+		}
+		if len(serviceAccount.Name) == 0 {
+			allErrs = append(allErrs, field.Required(path.Child("name"), "name must be set"))
+		}
+	}
+
+	if r.Spec.PodSelector != nil {
+		_, err := metav1.LabelSelectorAsSelector(r.Spec.PodSelector)
+		if err != nil {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child("podSelector"), r.Spec.PodSelector,
+				err.Error()))
+		}
+	}
+
+	type exemptPort struct {
+		port     int
+		protocol corev1.Protocol
+	}
+
+	ports := make(map[exemptPort]struct{})
+	for index, port := range r.Spec.Ports {
+		path := field.NewPath("spec").Child("ports").Index(index)
+
+		if port.Port < 1 || port.Port > 65535 {
+			allErrs = append(allErrs, field.Invalid(path.Child("port"), port.Port,
+				"port must be between 1 and 65535"))
+		}
+
+		if len(port.Protocol) > 0 && port.Protocol != corev1.ProtocolTCP && port.Protocol != corev1.ProtocolUDP && port.Protocol != corev1.ProtocolSCTP {
+			allErrs = append(allErrs, field.NotSupported(path.Child("protocol"), string(port.Protocol),
+				[]string{string(corev1.ProtocolTCP), string(corev1.ProtocolUDP), string(corev1.ProtocolSCTP)}))
+		}
+
+		key := exemptPort{port: port.Port, protocol: port.Protocol}
+		if _, ok := ports[key]; ok {
+			allErrs = append(allErrs, field.Duplicate(path, port.Port))
+		}
+		ports[key] = struct{}{}
+	}
+
+	return warnings, allErrs
+}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (d *HostPortExemptionValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	r, ok := obj.(*v1alpha1.HostPortExemption)
+	if !ok {
+		return nil, fmt.Errorf("expected a HostPortExemption object but got %T", obj)
+	}
+
+	hostportexemptionlog.Info("validate create", "name", r.Name)
+
+	warnings, allErrs := d.validateSpec(r)
+
+	if len(allErrs) == 0 {
+		return warnings, nil
+	}
+
+	return warnings, apierrors.NewInvalid(
+		schema.GroupKind{Group: v1alpha1.GroupVersion.Group, Kind: r.Kind},
+		r.Name, allErrs)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (d *HostPortExemptionValidator) ValidateUpdate(ctx context.Context, old runtime.Object, new runtime.Object) (admission.Warnings, error) {
+	r, ok := new.(*v1alpha1.HostPortExemption)
+	if !ok {
+		return nil, fmt.Errorf("expected a HostPortExemption new object but got %T", new)
+	}
+
+	hostportexemptionlog.Info("validate update", "name", r.Name)
+	_, ok = old.(*v1alpha1.HostPortExemption)
+	if !ok {
+		return nil, fmt.Errorf("expected a HostPortExemption old object but got %T", old)
+	}
+
+	warnings, allErrs := d.validateSpec(r)
+
+	if len(allErrs) == 0 {
+		return warnings, nil
+	}
+
+	return warnings, apierrors.NewInvalid(
+		schema.GroupKind{Group: v1alpha1.GroupVersion.Group, Kind: r.Kind},
+		r.Name, allErrs)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (d *HostPortExemptionValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	return nil, nil
+}
@@ -0,0 +1,92 @@
+package webhook
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/rmb938/hostport-allocator/api/v1alpha1"
+)
+
+func TestHostPortExemptionValidateSpec(t *testing.T) {
+	namespaces := []string{"kube-system"}
+
+	tests := []struct {
+		name     string
+		spec     v1alpha1.HostPortExemptionSpec
+		errs     int
+		warnings int
+	}{
+		{
+			name: "valid",
+			spec: v1alpha1.HostPortExemptionSpec{
+				Namespaces:  namespaces,
+				PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "proxy"}},
+				Ports:       []v1alpha1.HostPortExemptionPort{{Port: 80, Protocol: corev1.ProtocolTCP}, {Port: 80, Protocol: corev1.ProtocolUDP}},
+			},
+		},
+		{
+			name:     "no criteria",
+			spec:     v1alpha1.HostPortExemptionSpec{Ports: []v1alpha1.HostPortExemptionPort{{Port: 80}}},
+			warnings: 1,
+		},
+		{
+			name: "invalid pod selector",
+			spec: v1alpha1.HostPortExemptionSpec{
+				PodSelector: &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "app", Operator: "Bogus"},
+				}},
+				Ports: []v1alpha1.HostPortExemptionPort{{Port: 80}},
+			},
+			errs: 1,
+		},
+		{
+			name: "port out of range",
+			spec: v1alpha1.HostPortExemptionSpec{
+				Namespaces: namespaces,
+				Ports:      []v1alpha1.HostPortExemptionPort{{Port: 0}, {Port: 65536}},
+			},
+			errs: 2,
+		},
+		{
+			name: "unsupported protocol",
+			spec: v1alpha1.HostPortExemptionSpec{
+				Namespaces: namespaces,
+				Ports:      []v1alpha1.HostPortExemptionPort{{Port: 80, Protocol: "ICMP"}},
+			},
+			errs: 1,
+		},
+		{
+			name: "duplicate port",
+			spec: v1alpha1.HostPortExemptionSpec{
+				Namespaces: namespaces,
+				Ports:      []v1alpha1.HostPortExemptionPort{{Port: 80}, {Port: 80}},
+			},
+			errs: 1,
+		},
+		{
+			name: "incomplete service account",
+			spec: v1alpha1.HostPortExemptionSpec{
+				ServiceAccounts: []v1alpha1.HostPortExemptionServiceAccount{{Namespace: "kube-system"}},
+				Ports:           []v1alpha1.HostPortExemptionPort{{Port: 80}},
+			},
+			errs: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			d := &HostPortExemptionValidator{}
+			warnings, allErrs := d.validateSpec(&v1alpha1.HostPortExemption{Spec: test.spec})
+
+			if len(allErrs) != test.errs {
+				t.Errorf("expected %d errors but got %v", test.errs, allErrs)
+			}
+
+			if len(warnings) != test.warnings {
+				t.Errorf("expected %d warnings but got %v", test.warnings, warnings)
+			}
+		})
+	}
+}